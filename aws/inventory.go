@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// an account as cached after a refresh, role names only, credentials are never written to the cache
+type cachedAccount struct {
+	Id    string   `json:"id"`
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// Write the account inventory so apply can resolve account ids without a call to sso
+func WriteAccountCache(verbose bool, cacheFile string, accounts []Account) {
+	if verbose {
+		log.Println("writing account inventory", cacheFile)
+	}
+
+	var cached []cachedAccount
+	for _, account := range accounts {
+		roles := []string{}
+		for _, role := range account.Roles {
+			roles = append(roles, role.Name)
+		}
+		cached = append(cached, cachedAccount{account.Id, account.Name, roles})
+	}
+
+	inventory, err := json.MarshalIndent(cached, "", "  ")
+	check(err)
+
+	err = os.WriteFile(cacheFile, inventory, 0600)
+	if err != nil {
+		if verbose {
+			log.Println("error writing account inventory", cacheFile)
+		}
+		check(err)
+	}
+}
+
+// Read the account inventory written by the last refresh, empty when there hasn't been one
+func ReadAccountCache(verbose bool, cacheFile string) []Account {
+	inventory, err := os.ReadFile(cacheFile)
+	if err != nil {
+		if verbose {
+			log.Printf("no account inventory at %s, run override refresh to resolve account ids", cacheFile)
+		}
+		return nil
+	}
+
+	var cached []cachedAccount
+	if err := json.Unmarshal(inventory, &cached); err != nil {
+		if verbose {
+			log.Println("error reading account inventory", cacheFile)
+		}
+		check(err)
+	}
+
+	var accounts []Account
+	for _, c := range cached {
+		account := Account{Id: c.Id, Name: c.Name}
+		for _, role := range c.Roles {
+			account.Roles = append(account.Roles, Role{Name: role})
+		}
+		accounts = append(accounts, account)
+	}
+
+	if verbose {
+		log.Printf("read %d accounts from %s", len(accounts), cacheFile)
+	}
+
+	return accounts
+}
+
+func IsAccountId(id string) bool {
+	if len(id) != 12 {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// the account with the given id, if it's in the inventory
+func FindAccount(accounts []Account, id string) (Account, bool) {
+	for _, account := range accounts {
+		if account.Id == id {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
+
+// the account with the given name, if it's in the inventory
+func FindAccountByName(accounts []Account, name string) (Account, bool) {
+	for _, account := range accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
@@ -0,0 +1,41 @@
+package aws
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+)
+
+// the sso_account_id of every profile in an aws config file, keyed by profile name
+func ProfileAccountIds(verbose bool, configFile string) map[string]string {
+	accountIds := make(map[string]string)
+
+	file, err := os.Open(configFile)
+	if err != nil {
+		if verbose {
+			log.Println("error opening aws config file", configFile)
+		}
+		return accountIds
+	}
+	defer file.Close()
+
+	profile := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			profile = strings.TrimSpace(strings.TrimPrefix(strings.Trim(line, "[]"), "profile "))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if ok && profile != "" && strings.TrimSpace(key) == "sso_account_id" {
+			accountIds[profile] = strings.TrimSpace(value)
+		}
+	}
+	check(scanner.Err())
+
+	return accountIds
+}
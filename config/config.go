@@ -15,7 +15,6 @@ import (
 
 type ConfigOptions struct {
 	DefaultConfigFile                   string `json:"-"` // not configureable
-	DefaultIntermediateProviderFile     string `json:"-"`
 	DefaultMappingFile                  string `json:"-"`
 	DefaultTfPluginCache                string `json:"-"`
 	DefaultOverrideProviderFile         string `json:"overrides_provider_file,omitempty"`
@@ -23,7 +22,6 @@ type ConfigOptions struct {
 	DefaultWorkers                      int    `json:"threads,omitempty"`
 	DefaultVerbose                      bool   `json:"verbose"`
 	DefaultRefresh                      bool   `json:"refresh"`
-	DefaultTmpDir                       string `json:"tmp_dir,omitempty"`
 	DefaultUseCredentialsFile           bool   `json:"use_credentials_file"`
 	DefaultAwsSsoCacheDir               string `json:"aws_sso_cache_dir"`
 	DefaultAwsCredentialsFile           string `json:"aws_credentials_file"`
@@ -34,6 +32,17 @@ type ConfigOptions struct {
 	DefaultAwsRegion                    string `json:"aws_region"`
 	DefaultAwsSsoStartUrl               string `json:"aws_sso_start_url"`
 	DefaultResetAwsSsoConfigFile        bool   `json:"reset_to_default_aws_sso_config_file"`
+	DefaultLosslessRewrite              bool   `json:"lossless_rewrite"`
+	DefaultStripAttributes              string `json:"strip_attributes,omitempty"`
+	DefaultNativeOverride               bool   `json:"native_override"`
+	DefaultNativeOverrideFile           string `json:"native_override_file,omitempty"`
+	DefaultBackendOverrideFile          string `json:"backend_override_file,omitempty"`
+	DefaultRemoteStateOverrideFile      string `json:"remote_state_override_file,omitempty"`
+	DefaultJSONOverrides                bool   `json:"json_overrides"`
+	DefaultReadOnlyRole                 string `json:"read_only_role,omitempty"`
+	DefaultAccountCacheFile             string `json:"account_cache_file,omitempty"`
+	DefaultUserMappingDir               string `json:"user_mapping_dir,omitempty"`
+	DefaultTeamMappingFile              string `json:"team_mapping_file,omitempty"`
 	DefaultAwsSsoConfigFileUnderControl bool   `json:"-"`
 }
 
@@ -46,8 +55,8 @@ func newDefaultConfig() *ConfigOptions {
 	awsSsoCacheDir := filepath.Join(homeDir, ".aws", "sso", "cache")
 	awsSsoCredentialsFile := filepath.Join(homeDir, ".aws", "credentials")
 	awsSsoConfigFile := filepath.Join(homeDir, ".aws", "config")
-	tmpDir := os.TempDir()
-	intermediateProviderFile := "config.hcl"
+	accountCacheFile := filepath.Join(homeDir, ".override-accounts.json")
+	userMappingDir := filepath.Join(homeDir, ".override.d")
 	mappingFile := "mappings.hcl"
 	terraformCacheDir := ".terraform"
 	overrideProviderFile := "overrides.tf"
@@ -57,6 +66,14 @@ func newDefaultConfig() *ConfigOptions {
 	awsRegion := "eu-west-2"
 	awsSsoStartUrl := "https://<org>.awsapps.com/start"
 	resetAwsSsoConfigFile := false
+	losslessRewrite := false
+	stripAttributes := "assume_role,profile"
+	nativeOverride := false
+	nativeOverrideFile := "providers_override.tf"
+	backendOverrideFile := "backend_override.tf"
+	remoteStateOverrideFile := "remote_state_override.tf"
+	jsonOverrides := false
+	readOnlyRole := ""
 	useCredentialsFile := false
 	if runtime.GOOS == "windows" {
 		useCredentialsFile = true
@@ -65,14 +82,12 @@ func newDefaultConfig() *ConfigOptions {
 	return &ConfigOptions{
 		DefaultConfigFile:                   configFullFile,
 		DefaultMappingFile:                  mappingFile,
-		DefaultIntermediateProviderFile:     intermediateProviderFile,
 		DefaultTfPluginCache:                terraformCacheDir,
 		DefaultOverrideProviderFile:         overrideProviderFile,
 		DefaultBatch:                        4,
 		DefaultWorkers:                      12,
 		DefaultVerbose:                      false,
 		DefaultRefresh:                      false,
-		DefaultTmpDir:                       tmpDir,
 		DefaultUseCredentialsFile:           useCredentialsFile,
 		DefaultAwsSsoCacheDir:               awsSsoCacheDir,
 		DefaultAwsSsoConfigFile:             awsSsoConfigFile,
@@ -83,6 +98,17 @@ func newDefaultConfig() *ConfigOptions {
 		DefaultAwsRegion:                    awsRegion,
 		DefaultAwsSsoStartUrl:               awsSsoStartUrl,
 		DefaultResetAwsSsoConfigFile:        resetAwsSsoConfigFile,
+		DefaultLosslessRewrite:              losslessRewrite,
+		DefaultStripAttributes:              stripAttributes,
+		DefaultNativeOverride:               nativeOverride,
+		DefaultNativeOverrideFile:           nativeOverrideFile,
+		DefaultBackendOverrideFile:          backendOverrideFile,
+		DefaultRemoteStateOverrideFile:      remoteStateOverrideFile,
+		DefaultJSONOverrides:                jsonOverrides,
+		DefaultReadOnlyRole:                 readOnlyRole,
+		DefaultAccountCacheFile:             accountCacheFile,
+		DefaultUserMappingDir:               userMappingDir,
+		DefaultTeamMappingFile:              "",
 		DefaultAwsSsoConfigFileUnderControl: true,
 	}
 }
@@ -91,15 +117,6 @@ var appConfig *ConfigOptions
 
 // var app Override
 
-func init() {
-	// override config
-	appConfig = newDefaultConfig()
-	appConfig.readConfigFromDisk()
-	// aws config
-	appConfig.DefaultAwsSsoConfigFileUnderControl = appConfig.preReadAwsSsoConfig()
-	appConfig.takeOverAwsSsoConfig()
-}
-
 func (c ConfigOptions) rewriteAwsSsoConfig(file *os.File) {
 	template := `# overrides managed
 [profile %v]
@@ -231,7 +248,16 @@ func (c ConfigOptions) takeOverAwsSsoConfig() {
 	defer f.Close()
 }
 
+// the config is read the first time it's asked for rather than on import, so importing the package leaves ~ alone
 func GetConfig() *ConfigOptions {
+	if appConfig == nil {
+		// override config
+		appConfig = newDefaultConfig()
+		appConfig.readConfigFromDisk()
+		// aws config
+		appConfig.DefaultAwsSsoConfigFileUnderControl = appConfig.preReadAwsSsoConfig()
+		appConfig.takeOverAwsSsoConfig()
+	}
 	return appConfig
 }
 
@@ -256,10 +282,7 @@ func (c *ConfigOptions) readConfigFromDisk() {
 	}
 
 	// config reset just removes existing ~/.override file and app will rebuild it
-
-	if configFromDisk.DefaultTmpDir != c.DefaultTmpDir {
-		c.DefaultTmpDir = configFromDisk.DefaultTmpDir
-	}
+	// options checked for an empty value were added later, config files written before them don't have them so keep the default
 
 	if configFromDisk.DefaultOverrideProviderFile != c.DefaultOverrideProviderFile {
 		c.DefaultOverrideProviderFile = configFromDisk.DefaultOverrideProviderFile
@@ -281,10 +304,6 @@ func (c *ConfigOptions) readConfigFromDisk() {
 		c.DefaultRefresh = configFromDisk.DefaultRefresh
 	}
 
-	if configFromDisk.DefaultTmpDir != c.DefaultTmpDir {
-		c.DefaultTmpDir = configFromDisk.DefaultTmpDir
-	}
-
 	if configFromDisk.DefaultAwsCredentialsFile != c.DefaultAwsCredentialsFile {
 		c.DefaultAwsCredentialsFile = configFromDisk.DefaultAwsCredentialsFile
 	}
@@ -325,6 +344,50 @@ func (c *ConfigOptions) readConfigFromDisk() {
 		c.DefaultResetAwsSsoConfigFile = configFromDisk.DefaultResetAwsSsoConfigFile
 	}
 
+	if configFromDisk.DefaultLosslessRewrite != c.DefaultLosslessRewrite {
+		c.DefaultLosslessRewrite = configFromDisk.DefaultLosslessRewrite
+	}
+
+	if configFromDisk.DefaultStripAttributes != "" && configFromDisk.DefaultStripAttributes != c.DefaultStripAttributes {
+		c.DefaultStripAttributes = configFromDisk.DefaultStripAttributes
+	}
+
+	if configFromDisk.DefaultNativeOverride != c.DefaultNativeOverride {
+		c.DefaultNativeOverride = configFromDisk.DefaultNativeOverride
+	}
+
+	if configFromDisk.DefaultNativeOverrideFile != "" && configFromDisk.DefaultNativeOverrideFile != c.DefaultNativeOverrideFile {
+		c.DefaultNativeOverrideFile = configFromDisk.DefaultNativeOverrideFile
+	}
+
+	if configFromDisk.DefaultBackendOverrideFile != "" && configFromDisk.DefaultBackendOverrideFile != c.DefaultBackendOverrideFile {
+		c.DefaultBackendOverrideFile = configFromDisk.DefaultBackendOverrideFile
+	}
+
+	if configFromDisk.DefaultRemoteStateOverrideFile != "" && configFromDisk.DefaultRemoteStateOverrideFile != c.DefaultRemoteStateOverrideFile {
+		c.DefaultRemoteStateOverrideFile = configFromDisk.DefaultRemoteStateOverrideFile
+	}
+
+	if configFromDisk.DefaultJSONOverrides != c.DefaultJSONOverrides {
+		c.DefaultJSONOverrides = configFromDisk.DefaultJSONOverrides
+	}
+
+	if configFromDisk.DefaultReadOnlyRole != c.DefaultReadOnlyRole {
+		c.DefaultReadOnlyRole = configFromDisk.DefaultReadOnlyRole
+	}
+
+	if configFromDisk.DefaultAccountCacheFile != "" && configFromDisk.DefaultAccountCacheFile != c.DefaultAccountCacheFile {
+		c.DefaultAccountCacheFile = configFromDisk.DefaultAccountCacheFile
+	}
+
+	if configFromDisk.DefaultUserMappingDir != "" && configFromDisk.DefaultUserMappingDir != c.DefaultUserMappingDir {
+		c.DefaultUserMappingDir = configFromDisk.DefaultUserMappingDir
+	}
+
+	if configFromDisk.DefaultTeamMappingFile != c.DefaultTeamMappingFile {
+		c.DefaultTeamMappingFile = configFromDisk.DefaultTeamMappingFile
+	}
+
 }
 
 func (c ConfigOptions) writeConfigFileToDisk() {
@@ -341,8 +404,6 @@ func validateField(k string, v reflect.Value) (reflect.Value, error) {
 	var field reflect.Value
 
 	switch {
-	case k == "tmp_dir":
-		field = v.FieldByName("DefaultTmpDir")
 	case k == "chunks":
 		field = v.FieldByName("DefaultBatch")
 	case k == "threads":
@@ -373,6 +434,28 @@ func validateField(k string, v reflect.Value) (reflect.Value, error) {
 		field = v.FieldByName("DefaultAwsSsoConfigFile")
 	case k == "reset_to_default_aws_sso_config_file":
 		field = v.FieldByName("DefaultResetAwsSsoConfigFile")
+	case k == "lossless_rewrite":
+		field = v.FieldByName("DefaultLosslessRewrite")
+	case k == "strip_attributes":
+		field = v.FieldByName("DefaultStripAttributes")
+	case k == "native_override":
+		field = v.FieldByName("DefaultNativeOverride")
+	case k == "native_override_file":
+		field = v.FieldByName("DefaultNativeOverrideFile")
+	case k == "backend_override_file":
+		field = v.FieldByName("DefaultBackendOverrideFile")
+	case k == "remote_state_override_file":
+		field = v.FieldByName("DefaultRemoteStateOverrideFile")
+	case k == "json_overrides":
+		field = v.FieldByName("DefaultJSONOverrides")
+	case k == "read_only_role":
+		field = v.FieldByName("DefaultReadOnlyRole")
+	case k == "account_cache_file":
+		field = v.FieldByName("DefaultAccountCacheFile")
+	case k == "user_mapping_dir":
+		field = v.FieldByName("DefaultUserMappingDir")
+	case k == "team_mapping_file":
+		field = v.FieldByName("DefaultTeamMappingFile")
 	default:
 		return field, errors.New("error finding in config item")
 	}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/b0bul/override/config"
@@ -38,17 +39,118 @@ func main() {
 			{
 				Name:    "apply",
 				Aliases: []string{"a"},
-				Usage:   "Create an overrides.tf file backing up the provders.tf file",
+				Usage:   "Create an overrides.tf file backing up every file declaring a provider block",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
 						Name:     "alias",
 						Required: false,
-						Usage:    "If an environment alias is passed, replace the unaliased mapping.hcl profile environment with the new alias",
+						Usage:    "If an environment alias is passed, replace the {env} placeholder of mappings.hcl profiles with the new alias",
 						Action: func(cCtx *cli.Context, alias string) error {
 							app.SetAlias(alias)
 							return nil
 						},
 					},
+					&cli.StringSliceFlag{
+						Name:     "set",
+						Required: false,
+						Usage:    "Replace a placeholder of mappings.hcl profiles, passed as placeholder=value, can be passed multiple times",
+						Action: func(cCtx *cli.Context, placeholders []string) error {
+							app.SetPlaceholders(placeholders)
+							return nil
+						},
+					},
+					&cli.StringSliceFlag{
+						Name:     "var-file",
+						Required: false,
+						Usage:    "Load variable values from a .tfvars file, can be passed multiple times and takes precedence over terraform.tfvars and *.auto.tfvars",
+						Action: func(cCtx *cli.Context, varFiles []string) error {
+							app.SetVarFiles(varFiles)
+							return nil
+						},
+					},
+					&cli.BoolFlag{
+						Name:  "lossless",
+						Value: false,
+						Usage: "rewrite the original provider blocks replacing only credential attributes instead of generating new ones",
+						Action: func(cCtx *cli.Context, lossless bool) error {
+							app.LosslessRewriting(lossless)
+							return nil
+						},
+					},
+					&cli.StringSliceFlag{
+						Name:     "strip",
+						Required: false,
+						Usage:    "attributes and blocks removed from provider blocks when using --lossless, defaults to assume_role,profile",
+						Action: func(cCtx *cli.Context, strip []string) error {
+							app.SetStripAttributes(strip)
+							return nil
+						},
+					},
+					&cli.BoolFlag{
+						Name:  "local-backend",
+						Value: false,
+						Usage: "replace the backend or cloud block of the terraform block with a local backend while overrides are applied",
+						Action: func(cCtx *cli.Context, local bool) error {
+							app.UseLocalBackend(local)
+							return nil
+						},
+					},
+					&cli.BoolFlag{
+						Name:  "native",
+						Value: false,
+						Usage: "leave every .tf file untouched and write a providers_override.tf file that terraform merges into the aws providers",
+						Action: func(cCtx *cli.Context, native bool) error {
+							app.UseNativeOverride(native)
+							return nil
+						},
+					},
+					&cli.StringFlag{
+						Name:     "read-only-role",
+						Required: false,
+						Usage:    "keep assume_role in aws providers and replace the role name of role_arn with this role in the same account, instead of removing it",
+						Action: func(cCtx *cli.Context, role string) error {
+							app.SetReadOnlyRole(role)
+							return nil
+						},
+					},
+					&cli.StringFlag{
+						Name:     "region",
+						Required: false,
+						Usage:    "retarget every provider to this region, replacing the regions of the provider blocks and mappings.hcl",
+						Action: func(cCtx *cli.Context, region string) error {
+							app.SetRegion(region)
+							return nil
+						},
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Value: false,
+						Usage: "write the overrides file as terraform json syntax, overrides.tf.json, required when providers are declared in .tf.json files",
+						Action: func(cCtx *cli.Context, json bool) error {
+							app.UseJSONOverrides(json)
+							return nil
+						},
+					},
+					&cli.BoolFlag{
+						Name:  "recursive",
+						Value: false,
+						Usage: "apply overrides to every root module below --dir and print a summary of each, modules run in parallel up to --threads",
+					},
+					&cli.StringFlag{
+						Name:    "dir",
+						Aliases: []string{"chdir"},
+						Value:   ".",
+						Usage:   "the root module to apply overrides to, or with --recursive the directory to search for root modules",
+					},
+					&cli.IntFlag{
+						Name:  "threads",
+						Value: 12,
+						Usage: "Number of root modules processed at once with --recursive",
+						Action: func(cCtx *cli.Context, wcount int) error {
+							app.SetWorkers(wcount)
+							return nil
+						},
+					},
 					&cli.BoolFlag{
 						Name:  "verbose",
 						Value: false,
@@ -60,16 +162,60 @@ func main() {
 					},
 				},
 				Action: func(cCtx *cli.Context) error {
+					if cCtx.Bool("recursive") {
+						app.Recursive("apply", cCtx.String("dir"), overrides.ForwardedArgs(commandArgs()))
+						return nil
+					}
+					changeDir(cCtx.String("dir"))
+
 					if app.Verbose {
 						log.Println("--- Restoring working directory")
 					}
 
-					currentProviderFile, backedupProviderFile := provider.RestoreProvider(app.Verbose, app.OverrideProviderFile)
-					// detected based on filenames on disk, passed as app state for use later
-					app.ProviderFile = currentProviderFile
-					app.ProviderFileBackup = backedupProviderFile
+					provider.RestoreProvider(app.Verbose, app.OverrideProviderFile)
+					provider.RemoveOverrideFile(app.Verbose, app.NativeOverrideFile)
+					provider.RemoveModuleOverrideFiles(app.Verbose, app.NativeOverrideFile)
+					provider.RemoveManagedOverrideFile(app.Verbose, app.BackendOverrideFile)
+					provider.RemoveManagedOverrideFile(app.Verbose, app.RemoteStateOverrideFile)
+
+					// accounts cached by the last refresh, providers are matched to them by account id
+					app.LoadAccounts()
+
+					// configuration errors from here on put back every backed up file and remove the overrides written
+					provider.RollbackOnError(func() {
+						provider.RestoreProvider(app.Verbose, app.OverrideProviderFile)
+						provider.RemoveOverrideFile(app.Verbose, app.NativeOverrideFile)
+						provider.RemoveModuleOverrideFiles(app.Verbose, app.NativeOverrideFile)
+						provider.RemoveManagedOverrideFile(app.Verbose, app.BackendOverrideFile)
+						provider.RemoveManagedOverrideFile(app.Verbose, app.RemoteStateOverrideFile)
+					})
+
+					// terraform only runs in the copy terragrunt makes of this directory, an override file is copied with it
+					if provider.IsTerragruntDir() && !app.NativeOverride {
+						log.Printf("%s found, overriding providers natively", provider.TerragruntFile)
+						app.UseNativeOverride(true)
+					}
+
+					// locals and variables of the root module and the mappings resolved against them, shared by every override written
+					ctx := provider.ModuleEvalContext(app.Verbose, app.VarFiles)
+					mappings := app.ParseMappings(ctx)
+
+					if app.NativeOverride {
+						if app.Verbose {
+							log.Println("--- Writing native overrides")
+						}
+						app.WriteNativeOverrideFile(ctx, mappings)
+						app.WriteModuleOverrides(mappings)
+						app.WriteBackendOverrideFile(mappings)
+						app.WriteRemoteStateOverrideFile(mappings)
+						log.Println("Overrides applied")
+						return nil
+					}
+
+					app.CheckProviderSyntax()
 
-					provider.BackupProvider(app.Verbose, app.OverrideProviderFile, app.ProviderFile, app.ProviderFileBackup)
+					// detected based on provider blocks in every .tf and .tf.json file, passed as app state for use later
+					app.ProviderFileBackups = provider.BackupProvider(app.Verbose, app.OverrideProviderFile, app.LocalBackend)
 					if app.Verbose {
 						log.Println("--- Starting Overrides")
 					}
@@ -77,7 +223,10 @@ func main() {
 					if app.Verbose {
 						log.Println("--- Writing overrides")
 					}
-					app.WriteOverrideProvidersFileDynamic()
+					app.WriteOverrideProvidersFileDynamic(ctx, mappings)
+					app.WriteModuleOverrides(mappings)
+					app.WriteBackendOverrideFile(mappings)
+					app.WriteRemoteStateOverrideFile(mappings)
 					log.Println("Overrides applied")
 					return nil
 				},
@@ -176,8 +325,28 @@ func main() {
 			{
 				Name:    "restore",
 				Aliases: []string{"r"},
-				Usage:   "Resotre a providers.tf file and remove the overrides.tf file",
+				Usage:   "Resotre every file declaring a provider block and remove the overrides.tf file",
 				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "recursive",
+						Value: false,
+						Usage: "restore every root module below --dir and print a summary of each, modules run in parallel up to --threads",
+					},
+					&cli.StringFlag{
+						Name:    "dir",
+						Aliases: []string{"chdir"},
+						Value:   ".",
+						Usage:   "the root module to restore, or with --recursive the directory to search for root modules",
+					},
+					&cli.IntFlag{
+						Name:  "threads",
+						Value: 12,
+						Usage: "Number of root modules processed at once with --recursive",
+						Action: func(cCtx *cli.Context, wcount int) error {
+							app.SetWorkers(wcount)
+							return nil
+						},
+					},
 					&cli.BoolFlag{
 						Name:  "verbose",
 						Value: false,
@@ -189,10 +358,20 @@ func main() {
 					},
 				},
 				Action: func(cCtx *cli.Context) error {
+					if cCtx.Bool("recursive") {
+						app.Recursive("restore", cCtx.String("dir"), overrides.ForwardedArgs(commandArgs()))
+						return nil
+					}
+					changeDir(cCtx.String("dir"))
+
 					if app.Verbose {
 						log.Println("Restoring providers file")
 					}
 					provider.RestoreProvider(app.Verbose, app.OverrideProviderFile)
+					provider.RemoveOverrideFile(app.Verbose, app.NativeOverrideFile)
+					provider.RemoveModuleOverrideFiles(app.Verbose, app.NativeOverrideFile)
+					provider.RemoveManagedOverrideFile(app.Verbose, app.BackendOverrideFile)
+					provider.RemoveManagedOverrideFile(app.Verbose, app.RemoteStateOverrideFile)
 					log.Println("Done")
 					return nil
 				},
@@ -217,6 +396,46 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:    "mappings",
+				Aliases: []string{"m"},
+				Usage:   "interface for the mapping files layered into the mappings apply uses",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "show",
+						Usage: "List the mapping files apply reads, highest precedence first",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "resolved",
+								Value: false,
+								Usage: "print the merged mappings with the file each entry is declared in",
+							},
+							&cli.StringSliceFlag{
+								Name:     "var-file",
+								Required: false,
+								Usage:    "Load variable values from a .tfvars file when evaluating mappings, can be passed multiple times",
+								Action: func(cCtx *cli.Context, varFiles []string) error {
+									app.SetVarFiles(varFiles)
+									return nil
+								},
+							},
+							&cli.BoolFlag{
+								Name:  "verbose",
+								Value: false,
+								Usage: "Increased logging verbosity",
+								Action: func(cCtx *cli.Context, verbose bool) error {
+									app.VerboseLogging(verbose)
+									return nil
+								},
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							app.ShowMappings(cCtx.Bool("resolved"))
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:    "config",
 				Aliases: []string{"c"},
@@ -277,3 +496,26 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// the arguments following the command, passed on to the command run in each root module by --recursive
+func commandArgs() []string {
+	for i, arg := range os.Args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return os.Args[i+2:]
+		}
+	}
+	return nil
+}
+
+// run in another root module, like terraform -chdir. Relative --var-file paths are relative to it
+func changeDir(dir string) {
+	if dir == "." {
+		return
+	}
+	if app.Verbose {
+		log.Println("changing directory to", dir)
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatalln(err)
+	}
+}
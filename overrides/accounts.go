@@ -0,0 +1,293 @@
+package overrides
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/b0bul/override/aws"
+	pd "github.com/b0bul/override/provider"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const accountIdLocalSuffix string = "account_id"
+
+// account ids a provider targets, the account of its assume_role role_arn first then allowed_account_ids
+func targetAccountIds(provider pd.AwsProviderConfigBody) []string {
+	var ids []string
+
+	if provider.AssumeRole != nil {
+		arn := strings.Split(provider.AssumeRole.Template, ":")
+		if len(arn) >= 6 && aws.IsAccountId(arn[4]) {
+			ids = append(ids, arn[4])
+		}
+	}
+
+	return append(ids, provider.AllowedAccountIds...)
+}
+
+// Read the account inventory cached by the last refresh
+func (app *Override) LoadAccounts() {
+	app.Accounts = aws.ReadAccountCache(app.Verbose, app.AccountCacheFile)
+}
+
+// an *account_id local found in the account inventory
+type accountLocal struct {
+	name    string
+	account aws.Account
+}
+
+/*
+match every *account_id local against the account inventory, keyed by the provider alias the local is named after
+with dashes written as underscores, local.logs_account_id is the account of aws.logs and local.account_id the
+account of the unaliased provider
+*/
+func (app Override) accountIdLocals(ctx *hcl.EvalContext) map[string]accountLocal {
+	resolved := make(map[string]accountLocal)
+
+	locals, ok := ctx.Variables["local"]
+	if !ok || !locals.Type().IsObjectType() {
+		return resolved
+	}
+
+	var names []string
+	for name := range locals.Type().AttributeTypes() {
+		if strings.HasSuffix(name, accountIdLocalSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := locals.GetAttr(name)
+		if !value.IsKnown() || value.IsNull() || value.Type() != cty.String {
+			if app.Verbose {
+				log.Printf("local.%s can't be evaluated before plan", name)
+			}
+			continue
+		}
+
+		account, ok := aws.FindAccount(app.Accounts, value.AsString())
+		if !ok {
+			if app.Verbose {
+				log.Printf("local.%s %s is not in the account inventory", name, value.AsString())
+			}
+			continue
+		}
+		if app.Verbose {
+			log.Printf("local.%s is account %s (%s)", name, account.Name, account.Id)
+		}
+
+		alias := strings.TrimSuffix(strings.TrimSuffix(name, accountIdLocalSuffix), "_")
+		resolved[alias] = accountLocal{name, account}
+	}
+
+	return resolved
+}
+
+/*
+match the account each aws provider targets against the account inventory, returning the account of each provider,
+or instance of a provider with for_each, keyed by providerName. The account of its assume_role role_arn or
+allowed_account_ids comes first, otherwise the *account_id local named after its alias, see accountIdLocals.
+Providers targeting an account that isn't in the inventory, or nothing that can be evaluated, keep the profile
+chosen by alias
+*/
+func (app Override) resolveAccounts(ctx *hcl.EvalContext, providers []pd.AwsProviderConfigBody) map[string]aws.Account {
+	resolved := make(map[string]aws.Account)
+
+	if len(app.Accounts) == 0 {
+		return resolved
+	}
+
+	if app.Verbose {
+		log.Println("resolving account ids against the account inventory")
+	}
+
+	locals := app.accountIdLocals(ctx)
+
+	for _, provider := range providers {
+		if provider.Type != "aws" {
+			continue
+		}
+
+		for _, instance := range providerInstances(provider) {
+			for _, id := range targetAccountIds(instance) {
+				if account, ok := aws.FindAccount(app.Accounts, id); ok {
+					log.Printf("provider %s targets account %s (%s)", providerName(instance), account.Name, account.Id)
+					resolved[providerName(instance)] = account
+					break
+				}
+				log.Printf("provider %s targets account %s which is not in the account inventory", providerName(instance), id)
+			}
+
+			// instances of a provider with for_each share its alias, a local can't tell which account each targets
+			if _, ok := resolved[providerName(instance)]; ok || instance.InstanceKey != "" {
+				continue
+			}
+			if local, ok := locals[strings.ReplaceAll(instance.Alias, "-", "_")]; ok {
+				log.Printf("provider %s targets account %s (%s) of local.%s", providerName(instance), local.account.Name, local.account.Id, local.name)
+				resolved[providerName(instance)] = local.account
+			}
+		}
+	}
+
+	return resolved
+}
+
+// the alias mappings.hcl refers to a provider by, unaliased providers are unaliased and instances are <alias>[<key>]
+func providerAlias(provider pd.AwsProviderConfigBody) string {
+	if provider.Alias == "" {
+		return "unaliased"
+	}
+	if provider.InstanceKey != "" {
+		return provider.Alias + "[" + provider.InstanceKey + "]"
+	}
+	return provider.Alias
+}
+
+func providerName(provider pd.AwsProviderConfigBody) string {
+	if provider.Alias == "" {
+		return provider.Type
+	}
+	return provider.Type + "." + providerAlias(provider)
+}
+
+// the role of a profile in an account, profiles are written as <account name>-<role name> by refresh
+func profileRole(account aws.Account, profile string) string {
+	for _, role := range account.Roles {
+		if strings.HasSuffix(profile, "-"+role.Name) {
+			return role.Name
+		}
+	}
+	return profile[strings.LastIndex(profile, "-")+1:]
+}
+
+/*
+the profile of an aws provider, a profile from a mapping matching the provider other than default always wins,
+otherwise a provider whose account was resolved from the inventory gets the profile for that account with the
+role of its mapping, see mappingProfile
+*/
+func (app Override) providerProfile(provider pd.AwsProviderConfigBody, accounts map[string]aws.Account, providerMappings pd.OverrideConfig) string {
+	var target *aws.Account
+	if account, ok := accounts[providerName(provider)]; ok {
+		target = &account
+	}
+
+	return app.mappingProfile(providerName(provider), resolveMapping(mappingAliases(provider), providerMappings), target)
+}
+
+func allowedAccountIdsValue(ids []string) cty.Value {
+	if len(ids) == 0 {
+		return cty.ListValEmpty(cty.String)
+	}
+
+	var values []cty.Value
+	for _, id := range ids {
+		values = append(values, cty.StringVal(id))
+	}
+	return cty.ListVal(values)
+}
+
+/*
+the allowed_account_ids of every instance of an aws provider as an hcl expression, the evaluated ids so locals are
+resolved, or the expression as written when it's only known at plan. Empty when the provider doesn't declare it
+*/
+func allowedAccountIdsExpr(provider pd.AwsProviderConfigBody) string {
+	return instanceExpr(provider, func(instance pd.AwsProviderConfigBody) string {
+		switch {
+		case instance.AllowedAccountIds != nil:
+			return string(hclwrite.TokensForValue(allowedAccountIdsValue(instance.AllowedAccountIds)).Bytes())
+		case instance.AllowedAccountIdsSource != "":
+			return instance.AllowedAccountIdsSource
+		}
+		return ""
+	})
+}
+
+// allowed_account_ids line of a generated provider, empty when the provider doesn't declare it
+func allowedAccountIdsAttribute(provider pd.AwsProviderConfigBody) string {
+	expr := allowedAccountIdsExpr(provider)
+	if expr == "" {
+		return ""
+	}
+	return "\tallowed_account_ids = " + expr + "\n"
+}
+
+/*
+compare the account of every profile about to be written against the allowed_account_ids of its provider, so a wrong
+mapping fails apply instead of the first plan. A provider kept on a read only role is checked by the account of its
+role_arn, that's the account terraform ends up in. Every mismatch is reported before rolling back
+*/
+func (app Override) checkAllowedAccounts(config pd.ProviderConfig, accounts map[string]aws.Account, providerMappings pd.OverrideConfig) {
+	profileAccountIds := aws.ProfileAccountIds(app.Verbose, app.AwsSsoConfigFile)
+
+	var mismatches []string
+	var instances []pd.AwsProviderConfigBody
+	for _, provider := range config.Providers {
+		instances = append(instances, providerInstances(provider)...)
+	}
+
+	for _, provider := range instances {
+		if provider.Type != "aws" {
+			continue
+		}
+		if provider.AllowedAccountIds == nil {
+			if provider.AllowedAccountIdsSource != "" && app.Verbose {
+				log.Printf("provider %s allowed_account_ids %s is only known at plan, not checked", providerName(provider), provider.AllowedAccountIdsSource)
+			}
+			continue
+		}
+		// an empty allowed_account_ids restricts nothing
+		if len(provider.AllowedAccountIds) == 0 {
+			continue
+		}
+
+		var accountId, source string
+		if roleArn := app.readOnlyRoleArn(provider); roleArn != "" {
+			arn := strings.Split(roleArn, ":")
+			if len(arn) < 6 || !aws.IsAccountId(arn[4]) {
+				if app.Verbose {
+					log.Printf("account of provider %s role_arn %s can't be checked before plan", providerName(provider), roleArn)
+				}
+				continue
+			}
+			accountId, source = arn[4], "role_arn "+roleArn
+		} else {
+			profile := app.providerProfile(provider, accounts, providerMappings)
+			id, ok := profileAccountIds[profile]
+			if !ok {
+				log.Printf("profile %s of provider %s has no sso_account_id in %s, allowed_account_ids not checked", profile, providerName(provider), app.AwsSsoConfigFile)
+				continue
+			}
+			accountId, source = id, "profile "+profile
+		}
+
+		allowed := false
+		for _, id := range provider.AllowedAccountIds {
+			if id == accountId {
+				allowed = true
+				break
+			}
+		}
+
+		if allowed {
+			if app.Verbose {
+				log.Printf("provider %s %s is allowed account %s", providerName(provider), source, accountId)
+			}
+			continue
+		}
+		mismatches = append(mismatches, fmt.Sprintf("provider %s %s is account %s, allowed_account_ids are %s", providerName(provider), source, accountId, strings.Join(provider.AllowedAccountIds, ", ")))
+	}
+
+	if len(mismatches) == 0 {
+		return
+	}
+
+	for _, mismatch := range mismatches {
+		log.Println(mismatch)
+	}
+	pd.Fail(fmt.Errorf("%d provider(s) would use an account not in their allowed_account_ids, check mappings.hcl", len(mismatches)))
+}
@@ -0,0 +1,98 @@
+package overrides
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	pd "github.com/b0bul/override/provider"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+/*
+Write a backend_override.tf replacing the s3 backend with a copy that reads state and takes the dynamodb lock with
+the profile of the backend mapping in mappings.hcl. Terraform replaces a backend block from an override file as a
+whole so bucket, key, region, dynamodb_table and everything else are copied, only the credentials are swapped.
+Nothing is written without a backend mapping, or when the backend is replaced with a local one
+*/
+func (app Override) WriteBackendOverrideFile(providerMappings pd.OverrideConfig) {
+	if app.LocalBackend {
+		if app.Verbose {
+			log.Println("backend replaced with a local backend, skipping backend override")
+		}
+		return
+	}
+
+	if providerMappings.Backend == nil {
+		if app.Verbose {
+			log.Println("no backend mapping in", app.MappingFile, "skipping backend override")
+		}
+		return
+	}
+
+	backend := pd.FindS3Backend(app.Verbose)
+	if backend == nil {
+		return
+	}
+
+	pd.CheckManagedFile(app.BackendOverrideFile)
+
+	profile := providerMappings.Backend.Profile
+	log.Printf("overriding the s3 backend of %s with profile %s", backend.File, profile)
+
+	if backend.JSON != nil || app.JSONOverrides {
+		app.writeBackendOverrideFileJSON(backend, profile)
+		return
+	}
+
+	body := backend.Block.Body()
+	pd.StripProviderAttributes(app.Verbose, body, pd.BackendCredentialAttributes)
+	body.SetAttributeValue("profile", cty.StringVal(profile))
+	body.SetAttributeValue("shared_credentials_files", cty.ListVal([]cty.Value{cty.StringVal(app.AwsCredentialsFile)}))
+	body.SetAttributeValue("shared_config_files", cty.ListVal([]cty.Value{cty.StringVal(app.AwsSsoConfigFile)}))
+
+	overrides := hclwrite.NewEmptyFile()
+	overrides.Body().AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenComment, Bytes: []byte(pd.ManagedFileHeader + "\n")},
+	})
+	overrides.Body().AppendNewBlock("terraform", nil).Body().AppendBlock(backend.Block)
+
+	err := os.WriteFile(app.BackendOverrideFile, hclwrite.Format(overrides.Bytes()), 0644)
+	if err != nil {
+		if app.Verbose {
+			log.Println("error writing backend overrides file", app.BackendOverrideFile)
+		}
+	}
+	check(err)
+}
+
+// json equivalent of the backend override, a backend declared in json syntax can only be written back as json
+func (app Override) writeBackendOverrideFileJSON(backend *pd.S3Backend, profile string) {
+	if !app.JSONOverrides {
+		pd.Fail(fmt.Errorf("%s declares the backend in json syntax, apply with --json to write %s.json", backend.File, app.BackendOverrideFile))
+	}
+
+	body := backend.JSON
+	if body == nil {
+		body = pd.NativeBodyJSON(backend.Block.Body())
+	}
+
+	for _, name := range pd.BackendCredentialAttributes {
+		if _, ok := body[name]; ok {
+			if app.Verbose {
+				log.Println("stripping backend attribute", name)
+			}
+			delete(body, name)
+		}
+	}
+	body["profile"] = profile
+	body["shared_credentials_files"] = []string{app.AwsCredentialsFile}
+	body["shared_config_files"] = []string{app.AwsSsoConfigFile}
+
+	pd.WriteJSONFile(app.Verbose, app.BackendOverrideFile, pd.JSONObject{
+		pd.JSONCommentKey: pd.ManagedFileComment,
+		"terraform":       pd.JSONObject{"backend": pd.JSONObject{"s3": body}},
+	})
+}
@@ -0,0 +1,103 @@
+package overrides
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/b0bul/override/aws"
+	pd "github.com/b0bul/override/provider"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// instances of an aws provider, a provider without for_each, or whose for_each is only known at plan, is its only instance
+func providerInstances(provider pd.AwsProviderConfigBody) []pd.AwsProviderConfigBody {
+	if len(provider.Instances) == 0 {
+		return []pd.AwsProviderConfigBody{provider}
+	}
+	return provider.Instances
+}
+
+// mappings.hcl labels of a provider most specific first, an instance is mapped as <alias>[<key>] before its alias
+func mappingAliases(provider pd.AwsProviderConfigBody) []string {
+	if provider.InstanceKey == "" {
+		return []string{providerAlias(provider)}
+	}
+	return []string{providerAlias(provider), provider.Alias}
+}
+
+func quoted(s string) string {
+	return string(hclwrite.TokensForValue(cty.StringVal(s)).Bytes())
+}
+
+/*
+an hcl expression with a value per instance of a provider, written once when every instance has the same value and
+as a map indexed by each.key otherwise so the generated provider keeps its for_each. An empty value is null
+*/
+func instanceExpr(provider pd.AwsProviderConfigBody, expr func(pd.AwsProviderConfigBody) string) string {
+	instances := providerInstances(provider)
+
+	var values []string
+	first := expr(instances[0])
+	same := true
+	for i, instance := range instances {
+		value := first
+		if i > 0 {
+			value = expr(instance)
+		}
+		same = same && value == first
+
+		if value == "" {
+			value = "null"
+		}
+		values = append(values, fmt.Sprintf("%s = %s", quoted(instance.InstanceKey), value))
+	}
+
+	if same {
+		return first
+	}
+	return "{ " + strings.Join(values, ", ") + " }[each.key]"
+}
+
+// tokens of an expression built as text, for attributes that can't be written with SetAttributeValue
+func exprTokens(expr string) hclwrite.Tokens {
+	file, diags := hclwrite.ParseConfig([]byte("expr = "+expr+"\n"), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		check(diags)
+	}
+	return file.Body().GetAttribute("expr").Expr().BuildTokens(nil)
+}
+
+// an expression in json syntax, a quoted string is its value and anything else a "${...}" template
+func jsonExpr(expr string) string {
+	if len(expr) >= 2 && strings.HasPrefix(expr, `"`) && strings.HasSuffix(expr, `"`) && !strings.Contains(expr[1:len(expr)-1], `"`) {
+		return expr[1 : len(expr)-1]
+	}
+	return "${" + expr + "}"
+}
+
+// for_each line of a generated provider, empty unless the provider iterates
+func forEachAttribute(provider pd.AwsProviderConfigBody) string {
+	if provider.ForEach == nil {
+		return ""
+	}
+	return "\tfor_each = " + provider.ForEachSource + "\n"
+}
+
+// the profile of every instance of a provider as an hcl expression
+func (app Override) profileExpr(provider pd.AwsProviderConfigBody, accounts map[string]aws.Account, providerMappings pd.OverrideConfig) string {
+	return instanceExpr(provider, func(instance pd.AwsProviderConfigBody) string {
+		return quoted(app.providerProfile(instance, accounts, providerMappings))
+	})
+}
+
+// the read only role_arn of every instance of a provider as an hcl expression, empty when the role isn't replaced
+func (app Override) readOnlyRoleArnExpr(provider pd.AwsProviderConfigBody) string {
+	if app.ReadOnlyRole == "" || provider.AssumeRole == nil {
+		return ""
+	}
+	return instanceExpr(provider, func(instance pd.AwsProviderConfigBody) string {
+		return `"` + app.readOnlyRoleArn(instance) + `"`
+	})
+}
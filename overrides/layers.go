@@ -0,0 +1,202 @@
+package overrides
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pd "github.com/b0bul/override/provider"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// a mapping file layered into the mappings apply uses, Layer names where it's read from
+type mappingLayer struct {
+	Layer   string
+	File    string
+	Missing bool // the team mapping file is set in ~/.override but doesn't exist
+}
+
+func exists(f string) bool {
+	_, err := os.Stat(f)
+	return err == nil
+}
+
+// a path from ~/.override with ~ expanded
+func expandHome(f string) string {
+	if f != "~" && !strings.HasPrefix(f, "~/") {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	check(err)
+	return filepath.Join(home, strings.TrimPrefix(f, "~"))
+}
+
+/*
+every mapping file layered into the mappings apply uses, lowest precedence first: the .hcl files of the user mapping
+directory in name order, the team mapping file, mappings.hcl in each parent directory farthest first and last
+mappings.hcl of the root module. Files that don't exist are left out, except a team mapping file that's been set
+*/
+func (app Override) mappingLayers() []mappingLayer {
+	var layers []mappingLayer
+
+	if app.UserMappingDir != "" {
+		files, err := filepath.Glob(filepath.Join(expandHome(app.UserMappingDir), "*.hcl"))
+		check(err)
+		sort.Strings(files)
+		for _, f := range files {
+			layers = append(layers, mappingLayer{"user", f, false})
+		}
+	}
+
+	if app.TeamMappingFile != "" {
+		f := expandHome(app.TeamMappingFile)
+		layers = append(layers, mappingLayer{"team", f, !exists(f)})
+	}
+
+	dir, err := filepath.Abs(".")
+	check(err)
+
+	var parents []mappingLayer
+	for parent := filepath.Dir(dir); ; parent = filepath.Dir(parent) {
+		if f := filepath.Join(parent, filepath.Base(app.MappingFile)); exists(f) {
+			parents = append([]mappingLayer{{"parent", f, false}}, parents...)
+		}
+		if parent == filepath.Dir(parent) {
+			break
+		}
+	}
+	layers = append(layers, parents...)
+
+	if exists(app.MappingFile) {
+		layers = append(layers, mappingLayer{"repo", app.MappingFile, false})
+	}
+
+	return layers
+}
+
+/*
+the mappings of every layered mapping file merged, see pd.ParseOverrideConfig. With no mapping file anywhere
+mappings.hcl of the root module is still read, so a missing mappings.hcl is reported as it always was
+*/
+func (app Override) ParseMappings(ctx *hcl.EvalContext) pd.OverrideConfig {
+	var files []string
+	for _, layer := range app.mappingLayers() {
+		if layer.Missing {
+			if app.Verbose {
+				log.Printf("team mapping file %s doesn't exist, skipping", layer.File)
+			}
+			continue
+		}
+		if app.Verbose {
+			log.Printf("reading %s mapping file %s", layer.Layer, layer.File)
+		}
+		files = append(files, layer.File)
+	}
+
+	if len(files) == 0 {
+		files = []string{app.MappingFile}
+	}
+
+	return pd.ParseOverrideConfig(files, ctx)
+}
+
+// how closely a mapping label matches any alias it matches, see matchingMappings
+func labelKind(mapping pd.OverrideConfigBody) int {
+	switch {
+	case mapping.Alias == "default":
+		return defaultMatch
+	case mapping.IsPattern():
+		return patternMatch
+	}
+	return exactMatch
+}
+
+func sourceComment(source string) hclwrite.Tokens {
+	return hclwrite.Tokens{{Type: hclsyntax.TokenComment, Bytes: []byte("# " + source + "\n")}}
+}
+
+/*
+print the mapping files layered into the mappings apply uses, highest precedence first. resolved prints the merged
+mappings instead, as mappings.hcl with the file each entry is declared in above it and override blocks in the order
+they apply to an alias matching several of them
+*/
+func (app Override) ShowMappings(resolved bool) {
+	layers := app.mappingLayers()
+	if len(layers) == 0 {
+		log.Fatalln("no mapping files found, neither", app.MappingFile, "nor any layered under it")
+	}
+
+	if !resolved {
+		for i := len(layers) - 1; i >= 0; i-- {
+			if layers[i].Missing {
+				fmt.Printf("%-6s  %s (missing)\n", layers[i].Layer, layers[i].File)
+				continue
+			}
+			fmt.Printf("%-6s  %s\n", layers[i].Layer, layers[i].File)
+		}
+		return
+	}
+
+	mappings := app.ParseMappings(pd.ModuleEvalContext(app.Verbose, app.VarFiles))
+
+	out := hclwrite.NewEmptyFile()
+	body := out.Body()
+
+	if mappings.ProfileTemplate != "" {
+		body.AppendUnstructuredTokens(sourceComment(mappings.ProfileTemplateSource))
+		body.SetAttributeValue("profile_template", cty.StringVal(mappings.ProfileTemplate))
+		body.AppendNewline()
+	}
+
+	overrides := append([]pd.OverrideConfigBody(nil), mappings.Override...)
+	sort.SliceStable(overrides, func(i, j int) bool {
+		a, b := overrides[i], overrides[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return labelKind(a) > labelKind(b)
+	})
+
+	for _, mapping := range overrides {
+		body.AppendUnstructuredTokens(sourceComment(mapping.Source))
+		block := body.AppendNewBlock("override", []string{mapping.Alias}).Body()
+		for _, attribute := range []struct{ name, value string }{
+			{"profile", mapping.Profile},
+			{"account", mapping.Account},
+			{"account_id", mapping.AccountId},
+			{"role", mapping.Role},
+			{"region", mapping.Region},
+		} {
+			if attribute.value != "" {
+				block.SetAttributeValue(attribute.name, cty.StringVal(attribute.value))
+			}
+		}
+		if mapping.Priority != 0 {
+			block.SetAttributeValue("priority", cty.NumberIntVal(int64(mapping.Priority)))
+		}
+		if mapping.Attributes != cty.NilVal && !mapping.Attributes.IsNull() {
+			block.SetAttributeValue("attributes", mapping.Attributes)
+		}
+		body.AppendNewline()
+	}
+
+	if mappings.Backend != nil {
+		body.AppendUnstructuredTokens(sourceComment(mappings.Backend.Source))
+		body.AppendNewBlock("backend", nil).Body().SetAttributeValue("profile", cty.StringVal(mappings.Backend.Profile))
+		body.AppendNewline()
+	}
+
+	for _, remoteState := range mappings.RemoteStates {
+		body.AppendUnstructuredTokens(sourceComment(remoteState.Source))
+		body.AppendNewBlock("remote_state", []string{remoteState.Name}).Body().SetAttributeValue("profile", cty.StringVal(remoteState.Profile))
+		body.AppendNewline()
+	}
+
+	fmt.Print(string(hclwrite.Format(out.Bytes())))
+}
@@ -0,0 +1,130 @@
+package overrides
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeMappingFile(t *testing.T, f string, src string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a user mapping directory, a team mapping file and a repo with mappings.hcl in the root module and a parent
+func layeredMappings(t *testing.T) (Override, string) {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeMappingFile(t, filepath.Join(dir, "home", ".override.d", "b.hcl"), `
+override "logs" {
+  profile = "user-b-logs"
+}`)
+	writeMappingFile(t, filepath.Join(dir, "home", ".override.d", "a.hcl"), `
+profile_template = "{account}-{role}"
+
+override "default" {
+  profile = "user-default"
+}
+
+override "logs" {
+  profile = "user-a-logs"
+}
+
+override "dns" {
+  profile = "user-dns"
+}`)
+	writeMappingFile(t, filepath.Join(dir, "team.hcl"), `
+override "dns" {
+  profile = "team-dns"
+}
+
+override "network" {
+  profile = "team-network"
+}`)
+	writeMappingFile(t, filepath.Join(dir, "repo", "mappings.hcl"), `
+override "network" {
+  profile = "parent-network"
+}`)
+	writeMappingFile(t, filepath.Join(dir, "repo", "stacks", "logs", "mappings.hcl"), `
+profile_template = "{org}-{account}-{env}-{role}"
+
+override "logs" {
+  profile = "repo-logs"
+}`)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "repo", "stacks", "logs")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return Override{
+		MappingFile:     "mappings.hcl",
+		UserMappingDir:  filepath.Join(dir, "home", ".override.d"),
+		TeamMappingFile: filepath.Join(dir, "team.hcl"),
+	}, dir
+}
+
+func TestMappingLayers(t *testing.T) {
+	app, dir := layeredMappings(t)
+
+	// lowest precedence first, user files in name order
+	want := []mappingLayer{
+		{"user", filepath.Join(dir, "home", ".override.d", "a.hcl"), false},
+		{"user", filepath.Join(dir, "home", ".override.d", "b.hcl"), false},
+		{"team", filepath.Join(dir, "team.hcl"), false},
+		{"parent", filepath.Join(dir, "repo", "mappings.hcl"), false},
+		{"repo", "mappings.hcl", false},
+	}
+
+	got := app.mappingLayers()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("layers = %v, want %v", got, want)
+	}
+
+	app.TeamMappingFile = filepath.Join(dir, "missing.hcl")
+	if layer := app.mappingLayers()[2]; layer.Layer != "team" || !layer.Missing {
+		t.Errorf("team layer = %v, want it missing", layer)
+	}
+}
+
+func TestLayeredMappingPrecedence(t *testing.T) {
+	app, _ := layeredMappings(t)
+	mappings := app.ParseMappings(nil)
+
+	if mappings.ProfileTemplate != "{org}-{account}-{env}-{role}" {
+		t.Errorf("profile_template = %q, want the repo's", mappings.ProfileTemplate)
+	}
+
+	tests := []struct {
+		alias   string
+		profile string
+	}{
+		// the repo mapping overrides both user mappings for the same alias
+		{"logs", "repo-logs"},
+		{"dns", "team-dns"},
+		{"network", "parent-network"},
+		// only declared by the user, every higher layer leaves it alone
+		{"transit", "user-default"},
+	}
+
+	for _, test := range tests {
+		if profile := resolveMapping([]string{test.alias}, mappings).profile; profile != test.profile {
+			t.Errorf("alias %s profile = %q, want %q", test.alias, profile, test.profile)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package overrides
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/b0bul/override/aws"
+	pd "github.com/b0bul/override/provider"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// the placeholder of profile_template a role without an account replaces
+const rolePlaceholder string = "role"
+
+// how closely a mapping label matches, an exact alias before a pattern before default
+const (
+	defaultMatch = iota
+	patternMatch
+	exactMatch
+)
+
+// a mapping matching a provider, with what orders it against the other mappings matching the same provider
+type mappingMatch struct {
+	mapping    pd.OverrideConfigBody
+	kind       int
+	aliasIndex int // the alias it matched, lower is more specific, an instance before its alias
+	order      int // position in mappings.hcl
+}
+
+/*
+every mapping matching a provider, highest precedence first. An explicit priority wins, then an exact alias over a
+pattern over default, then a mapping for an instance over one for its alias, then the first in mappings.hcl.
+aliases are the mappings.hcl labels of the provider, most specific first
+*/
+func matchingMappings(aliases []string, providerMappings pd.OverrideConfig) []mappingMatch {
+	var matches []mappingMatch
+
+	for order, mapping := range providerMappings.Override {
+		for i, alias := range aliases {
+			if !mapping.Matches(alias) {
+				continue
+			}
+
+			kind := exactMatch
+			switch {
+			case mapping.Alias == "default":
+				kind = defaultMatch
+			case mapping.Alias != alias:
+				kind = patternMatch
+			}
+			matches = append(matches, mappingMatch{mapping, kind, i, order})
+			break
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.mapping.Priority != b.mapping.Priority:
+			return a.mapping.Priority > b.mapping.Priority
+		case a.kind != b.kind:
+			return a.kind > b.kind
+		case a.aliasIndex != b.aliasIndex:
+			return a.aliasIndex < b.aliasIndex
+		}
+		return a.order < b.order
+	})
+
+	return matches
+}
+
+// the mapping fields that apply to a provider, each taken from the highest precedence mapping setting it
+type resolvedMapping struct {
+	profile       string
+	account       string
+	accountId     string
+	role          string
+	region        string
+	regionDefault bool // the region came from the default mapping, it only fills in an unknown region
+	specific      bool // the profile, account or role came from a mapping other than default
+	attributes    map[string]cty.Value
+	template      string // profile_template of mappings.hcl
+}
+
+func resolveMapping(aliases []string, providerMappings pd.OverrideConfig) resolvedMapping {
+	resolved := resolvedMapping{attributes: make(map[string]cty.Value), template: providerMappings.ProfileTemplate}
+
+	// the first mapping naming a profile, account or role decides how the profile is made up
+	identity := false
+
+	for _, match := range matchingMappings(aliases, providerMappings) {
+		mapping := match.mapping
+
+		if !identity && (mapping.Profile != "" || mapping.Account != "" || mapping.AccountId != "" || mapping.Role != "") {
+			identity = true
+			resolved.profile, resolved.account, resolved.accountId, resolved.role = mapping.Profile, mapping.Account, mapping.AccountId, mapping.Role
+			resolved.specific = match.kind != defaultMatch
+		} else if identity && resolved.profile == "" {
+			// an account or role alone is completed by a lower precedence mapping
+			if !resolved.namesAccount() {
+				resolved.account, resolved.accountId = mapping.Account, mapping.AccountId
+			}
+			if resolved.role == "" {
+				resolved.role = mapping.Role
+			}
+			if !resolved.namesAccount() || resolved.role == "" {
+				resolved.profile = mapping.Profile
+			}
+		}
+
+		if resolved.region == "" && mapping.Region != "" {
+			resolved.region = mapping.Region
+			resolved.regionDefault = match.kind == defaultMatch
+		}
+
+		if mapping.Attributes != cty.NilVal && !mapping.Attributes.IsNull() {
+			for name, value := range mapping.Attributes.AsValueMap() {
+				if _, ok := resolved.attributes[name]; !ok {
+					resolved.attributes[name] = value
+				}
+			}
+		}
+	}
+
+	return resolved
+}
+
+func (resolved resolvedMapping) namesAccount() bool {
+	return resolved.account != "" || resolved.accountId != ""
+}
+
+/*
+the account a mapping names, looked up in the account inventory by account_id, or by account as an id or a name.
+An account name that isn't in the inventory is only used as written when there's no inventory to check it against,
+anything else not found fails apply. nil when the mapping names no account
+*/
+func (app Override) mappingAccount(name string, resolved resolvedMapping) *aws.Account {
+	id := resolved.accountId
+	if id == "" && aws.IsAccountId(resolved.account) {
+		id = resolved.account
+	}
+
+	switch {
+	case id != "":
+		if account, ok := aws.FindAccount(app.Accounts, id); ok {
+			return &account
+		}
+	case resolved.account != "":
+		if account, ok := aws.FindAccountByName(app.Accounts, resolved.account); ok {
+			return &account
+		}
+		if len(app.Accounts) == 0 {
+			log.Printf("no account inventory to check account %s of provider %s against, run override refresh", resolved.account, name)
+			return &aws.Account{Name: resolved.account}
+		}
+	default:
+		return nil
+	}
+
+	account := id
+	if account == "" {
+		account = resolved.account
+	}
+	pd.Fail(fmt.Errorf("account %s mapped to provider %s is not in the account inventory %s, run override refresh", account, name, app.AccountCacheFile))
+	return nil
+}
+
+// fail apply when a role named in mappings.hcl has no profile in an account from the inventory
+func (app Override) checkAccountRole(name string, account aws.Account, role string) {
+	if len(account.Roles) == 0 {
+		return
+	}
+
+	var roles []string
+	for _, accountRole := range account.Roles {
+		if accountRole.Name == role {
+			return
+		}
+		roles = append(roles, accountRole.Name)
+	}
+	pd.Fail(fmt.Errorf("role %s mapped to provider %s has no profile in account %s (%s), its roles are %s", role, name, account.Name, account.Id, strings.Join(roles, ", ")))
+}
+
+/*
+the profile a mapping makes up, a profile is used as written and an account and role resolve to the profile refresh
+wrote, <account name>-<role>. target is the account the provider targets in the inventory, it fills in the account
+of a mapping with a role but no account, and of the default mapping. A role without an account replaces {role} of
+the profile read with profile_template. Empty when no mapping has anything to make a profile from
+*/
+func (app Override) mappingProfile(name string, resolved resolvedMapping, target *aws.Account) string {
+	profile := resolved.profile
+	if profile != "" {
+		profile = app.substituteProfile(name, resolved.template, profile)
+	}
+
+	account := app.mappingAccount(name, resolved)
+	if account == nil && target != nil && (resolved.role != "" || !resolved.specific) {
+		account = target
+	}
+
+	role := resolved.role
+	if role != "" && account != nil {
+		app.checkAccountRole(name, *account, role)
+	}
+	if role == "" && account != nil && profile != "" && (resolved.namesAccount() || !resolved.specific) {
+		role = profileRole(*account, profile)
+	}
+
+	switch {
+	case account != nil && role != "":
+		// --alias and --set apply to a profile made up from the inventory the same as to one written in a mapping
+		return app.substituteProfile(name, resolved.template, account.Name+"-"+role)
+	case role != "" && profile != "":
+		return app.replaceProfileRole(name, resolved.template, profile, role)
+	case profile != "":
+		return profile
+	}
+
+	if app.Verbose {
+		log.Printf("no mapping for provider %s makes up a profile", name)
+	}
+	return ""
+}
+
+/*
+a profile with its {role} replaced, read with profile_template so neither the account nor the role is split on a
+dash. A profile profile_template doesn't make up fails apply
+*/
+func (app Override) replaceProfileRole(name string, template string, profile string, role string) string {
+	if template == "" {
+		template = pd.DefaultProfileTemplate
+	}
+	if !pd.HasPlaceholder(template, rolePlaceholder) {
+		pd.Fail(fmt.Errorf("profile_template %s has no {%s} placeholder to replace with role %s of provider %s", template, rolePlaceholder, role, name))
+	}
+
+	values, ok := pd.TemplateValues(template, profile)
+	if !ok {
+		pd.Fail(fmt.Errorf("profile %s of provider %s is not made up by profile_template %s, its role can't be replaced with %s", profile, name, template, role))
+	}
+	values[rolePlaceholder] = role
+
+	replaced, _ := pd.RenderTemplate(template, values)
+	return replaced
+}
+
+// the placeholder values passed by --set, with the environment passed by --alias as {env}
+func (app Override) placeholderValues() map[string]string {
+	values := make(map[string]string)
+	for name, value := range app.Placeholders {
+		values[name] = value
+	}
+	if app.Alias != "" {
+		values["env"] = app.Alias
+	}
+	return values
+}
+
+/*
+a profile of a mapping, or made up from the inventory, with --alias and --set applied. A profile written with placeholders is made up from their
+values, any other profile is read with profile_template and made up again with the placeholders replaced, so an
+account name holding dashes is never split. A placeholder without a value fails apply
+*/
+func (app Override) substituteProfile(name string, template string, profile string) string {
+	values := app.placeholderValues()
+
+	if len(pd.TemplatePlaceholders(profile)) > 0 {
+		rendered, missing := pd.RenderTemplate(profile, values)
+		if len(missing) > 0 {
+			pd.Fail(fmt.Errorf("profile %s of provider %s has no value for %s, pass it with --set or --alias", profile, name, strings.Join(missing, ", ")))
+		}
+		return rendered
+	}
+
+	if len(values) == 0 {
+		return profile
+	}
+
+	if template == "" {
+		template = pd.DefaultProfileTemplate
+		if app.Verbose {
+			log.Printf("no profile_template in %s, reading profiles as %s", app.MappingFile, template)
+		}
+	}
+
+	placeholders := make([]string, 0, len(values))
+	for placeholder := range values {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Strings(placeholders)
+
+	for _, placeholder := range placeholders {
+		if !pd.HasPlaceholder(template, placeholder) {
+			pd.Fail(fmt.Errorf("profile_template %s has no {%s} placeholder to replace with %s", template, placeholder, values[placeholder]))
+		}
+	}
+
+	current, ok := pd.TemplateValues(template, profile)
+	if !ok {
+		pd.Fail(fmt.Errorf("profile %s of provider %s is not made up by profile_template %s", profile, name, template))
+	}
+	for placeholder, value := range values {
+		current[placeholder] = value
+	}
+
+	rendered, missing := pd.RenderTemplate(template, current)
+	if len(missing) > 0 {
+		pd.Fail(fmt.Errorf("profile %s of provider %s has no value for %s, pass it with --set or --alias", profile, name, strings.Join(missing, ", ")))
+	}
+
+	if app.Verbose {
+		log.Printf("profile %s of provider %s is %s with placeholders replaced", profile, name, rendered)
+	}
+	return rendered
+}
+
+// an extra provider attribute from mappings.hcl, Expr is an hcl expression
+type mappingAttribute struct {
+	Name string
+	Expr string
+}
+
+// the extra provider attributes of every mapping matching a provider, sorted by name
+func (app Override) mappingAttributes(provider pd.AwsProviderConfigBody, providerMappings pd.OverrideConfig) []mappingAttribute {
+	values := make(map[string]map[string]cty.Value)
+	for _, instance := range providerInstances(provider) {
+		values[instance.InstanceKey] = resolveMapping(mappingAliases(instance), providerMappings).attributes
+	}
+
+	exprs := make(map[string]string)
+	for _, attributes := range values {
+		for name := range attributes {
+			exprs[name] = instanceExpr(provider, func(instance pd.AwsProviderConfigBody) string {
+				value, ok := values[instance.InstanceKey][name]
+				if !ok {
+					return ""
+				}
+				return string(hclwrite.TokensForValue(value).Bytes())
+			})
+		}
+	}
+
+	attributes := make([]mappingAttribute, 0, len(exprs))
+	for name, expr := range exprs {
+		attributes = append(attributes, mappingAttribute{name, expr})
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		return attributes[i].Name < attributes[j].Name
+	})
+
+	return attributes
+}
+
+// extra attribute lines of a generated provider
+func (app Override) mappingAttributeLines(provider pd.AwsProviderConfigBody, providerMappings pd.OverrideConfig) string {
+	var lines string
+	for _, attribute := range app.mappingAttributes(provider, providerMappings) {
+		lines += "\t" + attribute.Name + " = " + attribute.Expr + "\n"
+	}
+	return lines
+}
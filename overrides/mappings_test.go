@@ -0,0 +1,164 @@
+package overrides
+
+import (
+	"testing"
+
+	pd "github.com/b0bul/override/provider"
+)
+
+func TestResolveMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		aliases  []string
+		mappings []pd.OverrideConfigBody
+		profile  string
+		specific bool
+	}{
+		{
+			name:     "default",
+			aliases:  []string{"logs"},
+			mappings: []pd.OverrideConfigBody{{Alias: "default", Profile: "default-profile"}},
+			profile:  "default-profile",
+		},
+		{
+			name:    "exact over pattern over default",
+			aliases: []string{"transit-eu"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "default", Profile: "default-profile"},
+				{Alias: "transit-*", Profile: "glob-profile"},
+				{Alias: "transit-eu", Profile: "exact-profile"},
+			},
+			profile:  "exact-profile",
+			specific: true,
+		},
+		{
+			name:    "regex over default",
+			aliases: []string{"transit-eu"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "default", Profile: "default-profile"},
+				{Alias: "/^transit-(eu|us)$/", Profile: "regex-profile"},
+			},
+			profile:  "regex-profile",
+			specific: true,
+		},
+		{
+			name:    "priority over exact",
+			aliases: []string{"transit-eu"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "transit-eu", Profile: "exact-profile"},
+				{Alias: "transit-*", Profile: "glob-profile", Priority: 10},
+			},
+			profile:  "glob-profile",
+			specific: true,
+		},
+		{
+			name:    "priority over default",
+			aliases: []string{"logs"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "logs", Profile: "exact-profile"},
+				{Alias: "default", Profile: "default-profile", Priority: 1},
+			},
+			profile: "default-profile",
+		},
+		{
+			name:    "tie goes to the first pattern",
+			aliases: []string{"transit-eu"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "transit-?*", Profile: "first-profile"},
+				{Alias: "/^transit-/", Profile: "second-profile"},
+			},
+			profile:  "first-profile",
+			specific: true,
+		},
+		{
+			name:    "tie goes to the first exact mapping",
+			aliases: []string{"logs"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "logs", Profile: "first-profile", Priority: 5},
+				{Alias: "logs", Profile: "second-profile", Priority: 5},
+			},
+			profile:  "first-profile",
+			specific: true,
+		},
+		{
+			name:    "instance over its alias",
+			aliases: []string{"regional[\"eu\"]", "regional"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "regional", Profile: "alias-profile"},
+				{Alias: "regional[\"eu\"]", Profile: "instance-profile"},
+			},
+			profile:  "instance-profile",
+			specific: true,
+		},
+		{
+			name:    "an invalid pattern matches nothing",
+			aliases: []string{"transit-eu"},
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "default", Profile: "default-profile"},
+				{Alias: "/^transit-(eu$/", Profile: "invalid-profile", Priority: 10},
+			},
+			profile: "default-profile",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved := resolveMapping(test.aliases, pd.OverrideConfig{Override: test.mappings})
+			if resolved.profile != test.profile || resolved.specific != test.specific {
+				t.Errorf("resolved profile %q specific %v, want %q specific %v", resolved.profile, resolved.specific, test.profile, test.specific)
+			}
+		})
+	}
+}
+
+func TestResolveMappingCompletesIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		mappings  []pd.OverrideConfigBody
+		account   string
+		accountId string
+		role      string
+		region    string
+	}{
+		{
+			name: "role from default",
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "default", Role: "ReadOnly", Region: "eu-west-1"},
+				{Alias: "logs", Account: "org-logs-prod"},
+			},
+			account: "org-logs-prod",
+			role:    "ReadOnly",
+			region:  "eu-west-1",
+		},
+		{
+			name: "account from a pattern",
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "log*", AccountId: "222233334444", Region: "us-east-1"},
+				{Alias: "logs", Role: "CodeContributor"},
+			},
+			accountId: "222233334444",
+			role:      "CodeContributor",
+			region:    "us-east-1",
+		},
+		{
+			name: "region from the closest match",
+			mappings: []pd.OverrideConfigBody{
+				{Alias: "default", Account: "org-main-dev", Role: "ReadOnly", Region: "eu-west-1"},
+				{Alias: "logs", Region: "eu-central-1"},
+			},
+			account: "org-main-dev",
+			role:    "ReadOnly",
+			region:  "eu-central-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolved := resolveMapping([]string{"logs"}, pd.OverrideConfig{Override: test.mappings})
+			if resolved.account != test.account || resolved.accountId != test.accountId || resolved.role != test.role || resolved.region != test.region {
+				t.Errorf("resolved account %q account_id %q role %q region %q, want %q %q %q %q",
+					resolved.account, resolved.accountId, resolved.role, resolved.region, test.account, test.accountId, test.role, test.region)
+			}
+		})
+	}
+}
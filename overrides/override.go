@@ -17,88 +17,86 @@ import (
 	co "github.com/b0bul/override/config"
 	pd "github.com/b0bul/override/provider"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
 )
 
+// an error once files are backed up or overrides written rolls the working directory back, see pd.Fail
 func check(err error) {
 	if err != nil {
-		log.Fatal(err)
+		pd.Fail(err)
 	}
 }
 
 type Override struct {
-	ConfigFile               string
-	MappingFile              string
-	IntermediateProviderFile string
-	TfPluginCacheDir         string
-	OverrideProviderFile     string
-	Accounts                 []aws.Account
-	Batch                    int
-	Verbose                  bool
-	Workers                  int
-	Refresh                  bool
-	Alias                    string
-	ConfigPath               string
-	TmpDir                   string
-	UseCredentialsFile       bool
-	AwsSsoCacheDir           string
-	AwsSsoConfigFile         string
-	AwsCredentialsFile       string
-	ProviderFile             string
-	ProviderFileBackup       string
-	AwsRegion                string
-	AwsSsoStartUrl           string
-	ResetAwsSsoConfigFile    bool
+	ConfigFile              string
+	MappingFile             string
+	TfPluginCacheDir        string
+	OverrideProviderFile    string
+	Accounts                []aws.Account
+	Batch                   int
+	Verbose                 bool
+	Workers                 int
+	Refresh                 bool
+	Alias                   string
+	Placeholders            map[string]string // profile placeholder values passed by --set
+	ConfigPath              string
+	UseCredentialsFile      bool
+	AwsSsoCacheDir          string
+	AwsSsoConfigFile        string
+	AwsCredentialsFile      string
+	ProviderFileBackups     []string
+	AwsRegion               string
+	AwsSsoStartUrl          string
+	ResetAwsSsoConfigFile   bool
+	AccountCacheFile        string
+	UserMappingDir          string // every .hcl file in it is layered under mappings.hcl
+	TeamMappingFile         string // layered between the user mapping files and mappings.hcl
+	VarFiles                []string
+	LosslessRewrite         bool
+	StripAttributes         []string
+	LocalBackend            bool
+	NativeOverride          bool
+	NativeOverrideFile      string
+	BackendOverrideFile     string
+	RemoteStateOverrideFile string
+	JSONOverrides           bool
+	ReadOnlyRole            string
+	Region                  string
 }
 
 func InitializeOverrideApp(c *co.ConfigOptions) Override {
-	return Override{
-		Batch:                    c.DefaultBatch,
-		Workers:                  c.DefaultWorkers,
-		Verbose:                  c.DefaultVerbose,
-		Refresh:                  c.DefaultRefresh,
-		TmpDir:                   c.DefaultTmpDir,
-		UseCredentialsFile:       c.DefaultUseCredentialsFile,
-		AwsSsoCacheDir:           c.DefaultAwsSsoCacheDir,
-		AwsSsoConfigFile:         c.DefaultAwsSsoConfigFile,
-		AwsCredentialsFile:       c.DefaultAwsCredentialsFile,
-		OverrideProviderFile:     c.DefaultOverrideProviderFile,
-		IntermediateProviderFile: c.DefaultIntermediateProviderFile,
-		MappingFile:              c.DefaultMappingFile,
-		AwsRegion:                c.DefaultAwsRegion,
-		AwsSsoStartUrl:           c.DefaultAwsSsoStartUrl,
-		ResetAwsSsoConfigFile:    c.DefaultResetAwsSsoConfigFile,
+	app := Override{
+		Batch:                   c.DefaultBatch,
+		Workers:                 c.DefaultWorkers,
+		Verbose:                 c.DefaultVerbose,
+		Refresh:                 c.DefaultRefresh,
+		UseCredentialsFile:      c.DefaultUseCredentialsFile,
+		AwsSsoCacheDir:          c.DefaultAwsSsoCacheDir,
+		AwsSsoConfigFile:        c.DefaultAwsSsoConfigFile,
+		AwsCredentialsFile:      c.DefaultAwsCredentialsFile,
+		OverrideProviderFile:    c.DefaultOverrideProviderFile,
+		MappingFile:             c.DefaultMappingFile,
+		AwsRegion:               c.DefaultAwsRegion,
+		AwsSsoStartUrl:          c.DefaultAwsSsoStartUrl,
+		ResetAwsSsoConfigFile:   c.DefaultResetAwsSsoConfigFile,
+		AccountCacheFile:        c.DefaultAccountCacheFile,
+		UserMappingDir:          c.DefaultUserMappingDir,
+		TeamMappingFile:         c.DefaultTeamMappingFile,
+		LosslessRewrite:         c.DefaultLosslessRewrite,
+		StripAttributes:         strings.Split(c.DefaultStripAttributes, ","),
+		NativeOverride:          c.DefaultNativeOverride,
+		NativeOverrideFile:      c.DefaultNativeOverrideFile,
+		BackendOverrideFile:     c.DefaultBackendOverrideFile,
+		RemoteStateOverrideFile: c.DefaultRemoteStateOverrideFile,
+		ReadOnlyRole:            c.DefaultReadOnlyRole,
 	}
-}
-
-// add new locals here
-type LocalValues struct {
-	RepositoryName string            `hcl:"repository_name,optional"`
-	DefaultTags    map[string]string `hcl:"default_tags,optional"`
-	Options        hcl.Body          `hcl:",remain"` // discard
-}
+	app.UseJSONOverrides(c.DefaultJSONOverrides)
 
-type LocalsBlock struct {
-	Locals  []LocalValues `hcl:"locals,block"`
-	Options hcl.Body      `hcl:",remain"` // discard
+	return app
 }
 
-/*
-search all .tf files and create a slice of locals for each locals block found
-Ignore all locals value that are not defined in the schema of the LocalValues struct
-When new locals are reuqired for templating / resolving local.something to its value
-It must be added to the struct
-
-This slice of structs is then merged together
-
-todo
-- search all .tf files in the dir
-- convert them all to /tmp/dirname.filename.hcl
-- parse out all locals, including multiple locals from single file
-- return as expected
-- map default default values setup in in-memory locals to locals from files
-
-*/
-
 func (app Override) WriteSsoProfiles() {
 
 	if app.UseCredentialsFile {
@@ -143,45 +141,31 @@ func (app Override) WriteSsoProfiles() {
 
 // returns the default profile, unless there's a special case where a provider block is unaliased, OR requires a role other than the default role
 func (app Override) setProviderProfile(alias string, providerMappings pd.OverrideConfig) string {
-
-	mappings := make(map[string]string)
-
-	// convert struct to map
-	for _, provider := range providerMappings.Override {
-		// if an environment alias is passed, replace the environment with the new alias
-		if app.Alias != "" {
-			profile := strings.Split(provider.Profile, "-")
-			profile[2] = app.Alias
-
-			providerProfile := strings.Join(profile, "-")
-			mappings[provider.Alias] = providerProfile
-		} else {
-			mappings[provider.Alias] = provider.Profile
-		}
-	}
-
-	if _, ok := mappings[alias]; ok {
-		return mappings[alias]
-	}
-	return mappings["default"]
+	return app.mappingProfile(alias, resolveMapping([]string{alias}, providerMappings), nil)
 }
 
 // Write the overrides.tf file - this data structure will be replaced by a dynamic
-func (app Override) WriteOverrideProvidersFileDynamic() {
+func (app Override) WriteOverrideProvidersFileDynamic(ctx *hcl.EvalContext, providerMappings pd.OverrideConfig) {
 
 	if app.Verbose {
 		log.Println("writing overrides file")
 	}
 
-	config := pd.ParseProviderFile(app.Verbose, app.IntermediateProviderFile, app.ProviderFileBackup, app.TmpDir)
+	config := pd.ParseProviderFile(app.Verbose, app.ProviderFileBackups, ctx)
 
-	defaultTags := pd.ExtractDefaultTags(app.Verbose, config.Providers)
+	accounts := app.resolveAccounts(ctx, config.Providers)
 
-	// temp solution until dynamic schema can be generated
-	if config.Terraform != nil {
-		log.Fatalln("You have a 'terraform {}' block in your providers.tf, please move ethis to version.tf")
+	app.checkAllowedAccounts(config, accounts, providerMappings)
+
+	if app.JSONOverrides {
+		app.writeOverrideProvidersFileJSON(config, accounts, providerMappings)
+		return
 	}
 
+	providerBlocks := pd.ProviderBlocks(pd.ProviderFileASTs(app.Verbose, app.ProviderFileBackups)...)
+
+	defaultTags := pd.ExtractDefaultTags(app.Verbose, config.Providers, providerBlocks)
+
 	if app.Verbose {
 		log.Println("opening overrides file", app.OverrideProviderFile)
 	}
@@ -197,24 +181,30 @@ func (app Override) WriteOverrideProvidersFileDynamic() {
 
 	defer file.Close()
 
-	// read mappings.hcl
-	providerMappings := pd.ParseOverrideConfig(app.MappingFile)
+	if app.LosslessRewrite {
+		app.writeOverrideProvidersFileLossless(file, providerBlocks, config, accounts, providerMappings)
+		return
+	}
+
 	escapedcredsPath := strings.ReplaceAll(app.AwsCredentialsFile, `\`, `\\`)
 	escapedSsoConfigPath := strings.ReplaceAll(app.AwsSsoConfigFile, `\`, `\\`)
 
-	for _, provider := range config.Providers {
+	for i, provider := range config.Providers {
 		switch {
 		// leaving support for other providers
 		// where no default tags are provided they are written empty
 		case provider.Type == "aws":
 			// handle default provider
 			if provider.Alias == "" {
-				_, err := file.Write([]byte(fmt.Sprintf("provider \"aws\" {\n	region = \"%v\"\n	profile = \"%v\"\n	shared_credentials_files = [\"%v\"]\n	shared_config_files = [\"%v\"]\n default_tags {\n   tags = {\n %v       }\n    }\n}\n",
-					provider.Region,
-					app.setProviderProfile("unaliased", providerMappings),
+				_, err := file.Write([]byte(fmt.Sprintf("provider \"aws\" {\n%v	profile = %v\n	shared_credentials_files = [\"%v\"]\n	shared_config_files = [\"%v\"]\n%v%v default_tags {\n   tags = %v\n    }\n%v}\n",
+					app.regionAttribute(provider, providerMappings),
+					app.profileExpr(provider, accounts, providerMappings),
 					escapedcredsPath,
 					escapedSsoConfigPath,
-					defaultTags["unaliased"],
+					allowedAccountIdsAttribute(provider),
+					app.mappingAttributeLines(provider, providerMappings),
+					defaultTags[pd.ProviderKey(provider.Type, provider.Alias)],
+					app.readOnlyAssumeRole(provider),
 				)))
 				if err != nil {
 					if app.Verbose {
@@ -225,13 +215,17 @@ func (app Override) WriteOverrideProvidersFileDynamic() {
 				// handle all others
 			} else {
 
-				_, err := file.Write([]byte(fmt.Sprintf("provider \"aws\" {\n	region = \"%v\"\n	alias = \"%v\"\n	profile = \"%v\"\n	shared_credentials_files = [\"%v\"]\n	shared_config_files = [\"%v\"]\n default_tags {\n   tags = {\n %v       }\n    }\n}\n",
-					provider.Region,
+				_, err := file.Write([]byte(fmt.Sprintf("provider \"aws\" {\n%v%v	alias = \"%v\"\n	profile = %v\n	shared_credentials_files = [\"%v\"]\n	shared_config_files = [\"%v\"]\n%v%v default_tags {\n   tags = %v\n    }\n%v}\n",
+					forEachAttribute(provider),
+					app.regionAttribute(provider, providerMappings),
 					provider.Alias,
-					app.setProviderProfile(provider.Alias, providerMappings),
+					app.profileExpr(provider, accounts, providerMappings),
 					escapedcredsPath,
 					escapedSsoConfigPath,
-					defaultTags[provider.Alias],
+					allowedAccountIdsAttribute(provider),
+					app.mappingAttributeLines(provider, providerMappings),
+					defaultTags[pd.ProviderKey(provider.Type, provider.Alias)],
+					app.readOnlyAssumeRole(provider),
 				)))
 				if err != nil {
 					if app.Verbose {
@@ -240,28 +234,371 @@ func (app Override) WriteOverrideProvidersFileDynamic() {
 				}
 				check(err)
 			}
-		case provider.Type == "template":
-			if provider.Alias == "" {
-				_, err := file.Write([]byte("provider \"template\" {}\n\n"))
-				if err != nil {
-					if app.Verbose {
-						log.Println("error writing template provider to overrides file")
-					}
+		// every other provider type, aliased or not, is copied verbatim including its full body
+		default:
+			if app.Verbose {
+				log.Printf("copying %s provider to overrides file", provider.Type)
+			}
+			_, err := file.Write(append(providerBlocks[i].BuildTokens(nil).Bytes(), '\n'))
+			if err != nil {
+				if app.Verbose {
+					log.Printf("error writing %s provider to overrides file %s", provider.Type, app.OverrideProviderFile)
 				}
-				check(err)
 			}
-		case provider.Type == "archive":
-			if provider.Alias == "" {
-				_, err := file.Write([]byte("provider \"archive\" {}\n\n"))
-				if err != nil {
-					if app.Verbose {
-						log.Println("error writing archive provider to overrides file")
+			check(err)
+		}
+	}
+}
+
+// role_arn of an aws provider pointed at the read only role, empty unless one is configured and the provider assumes a role
+func (app Override) readOnlyRoleArn(provider pd.AwsProviderConfigBody) string {
+	if app.ReadOnlyRole == "" || provider.AssumeRole == nil {
+		return ""
+	}
+	return pd.ReadOnlyRoleArn(providerName(provider), provider.AssumeRole.Template, app.ReadOnlyRole)
+}
+
+// assume_role block of a generated provider, only written when a read only role is configured
+func (app Override) readOnlyAssumeRole(provider pd.AwsProviderConfigBody) string {
+	roleArn := app.readOnlyRoleArnExpr(provider)
+	if roleArn == "" {
+		return ""
+	}
+	assumeRole := fmt.Sprintf(" assume_role {\n   role_arn = %v\n", roleArn)
+	for _, attribute := range provider.AssumeRole.Attributes {
+		assumeRole += fmt.Sprintf("   %s = %s\n", attribute.Name, attribute.Expr)
+	}
+	return assumeRole + "    }\n"
+}
+
+// attributes stripped by a lossless rewrite, assume_role is kept when it's pointed at the read only role
+func (app Override) stripAttributes(roleArn string) []string {
+	if roleArn == "" {
+		return app.StripAttributes
+	}
+
+	var strip []string
+	for _, name := range app.StripAttributes {
+		if name != "assume_role" {
+			strip = append(strip, name)
+		}
+	}
+	return strip
+}
+
+// Write the overrides.tf file from the original provider blocks, only the stripped credential attributes are replaced
+// so endpoints, ignore_tags, skip_* and any other attribute carry through unchanged
+func (app Override) writeOverrideProvidersFileLossless(file *os.File, providerBlocks []*hclwrite.Block, config pd.ProviderConfig, accounts map[string]aws.Account, providerMappings pd.OverrideConfig) {
+
+	if app.Verbose {
+		log.Println("rewriting provider blocks losslessly, stripping", app.StripAttributes)
+	}
+
+	for i, block := range providerBlocks {
+		provider := config.Providers[i]
+
+		if provider.Type == "aws" {
+			alias := provider.Alias
+			if alias == "" {
+				alias = "unaliased"
+			}
+
+			roleArn := app.readOnlyRoleArnExpr(provider)
+
+			body := block.Body()
+			pd.StripProviderAttributes(app.Verbose, body, app.stripAttributes(roleArn))
+			if assumeRole := body.FirstMatchingBlock("assume_role", nil); assumeRole != nil && roleArn != "" {
+				assumeRole.Body().SetAttributeRaw("role_arn", exprTokens(roleArn))
+			}
+			if region := app.regionOverrideExpr(provider, providerMappings); region != "" {
+				body.SetAttributeRaw("region", exprTokens(region))
+			}
+			body.SetAttributeRaw("profile", exprTokens(app.profileExpr(provider, accounts, providerMappings)))
+			body.SetAttributeValue("shared_credentials_files", cty.ListVal([]cty.Value{cty.StringVal(app.AwsCredentialsFile)}))
+			body.SetAttributeValue("shared_config_files", cty.ListVal([]cty.Value{cty.StringVal(app.AwsSsoConfigFile)}))
+			// kept unless stripped, written as the evaluated ids so locals are resolved
+			if expr := allowedAccountIdsExpr(provider); body.GetAttribute("allowed_account_ids") != nil && expr != "" {
+				body.SetAttributeRaw("allowed_account_ids", exprTokens(expr))
+			}
+			for _, attribute := range app.mappingAttributes(provider, providerMappings) {
+				body.SetAttributeRaw(attribute.Name, exprTokens(attribute.Expr))
+			}
+		}
+
+		// inserted attributes are aligned with the ones kept, as terraform fmt would write them
+		_, err := file.Write(append(hclwrite.Format(block.BuildTokens(nil).Bytes()), '\n'))
+		if err != nil {
+			if app.Verbose {
+				log.Printf("error writing %s provider to overrides file %s", provider.Type, app.OverrideProviderFile)
+			}
+		}
+		check(err)
+	}
+}
+
+/*
+Write the overrides.tf.json file, providers are written as an array so they keep the order they're declared in.
+Generated aws providers carry region, alias and default tags, a lossless rewrite keeps the original body and every
+other provider is copied as written, native syntax providers are converted to json
+*/
+func (app Override) writeOverrideProvidersFileJSON(config pd.ProviderConfig, accounts map[string]aws.Account, providerMappings pd.OverrideConfig) {
+
+	bodies := pd.ProviderJSONBodies(app.Verbose, app.ProviderFileBackups)
+
+	var providers []interface{}
+
+	for _, provider := range config.Providers {
+		body := bodies[pd.ProviderKey(provider.Type, provider.Alias)]
+
+		if provider.Type == "aws" {
+			alias := provider.Alias
+			if alias == "" {
+				alias = "unaliased"
+			}
+
+			roleArn := app.readOnlyRoleArnExpr(provider)
+
+			if app.LosslessRewrite {
+				for _, name := range app.stripAttributes(roleArn) {
+					if _, ok := body[name]; ok {
+						if app.Verbose {
+							log.Println("stripping provider attribute", name)
+						}
+						delete(body, name)
 					}
 				}
-				check(err)
+				if region := app.regionOverrideExpr(provider, providerMappings); region != "" {
+					body["region"] = jsonExpr(region)
+				}
+			} else {
+				body = pd.JSONObject{
+					"default_tags": pd.JSONObject{"tags": pd.DefaultTagsJSON(provider, body)},
+				}
+				if provider.ForEach != nil {
+					body["for_each"] = jsonExpr(provider.ForEachSource)
+				}
+				if region := app.regionExpr(provider, providerMappings); region != "" {
+					body["region"] = jsonExpr(region)
+				}
+				if provider.Alias != "" {
+					body["alias"] = provider.Alias
+				}
+				if roleArn != "" {
+					body["assume_role"] = pd.JSONObject{}
+				}
+			}
+
+			if roleArn != "" {
+				pd.SetJSONBlockAttribute(body, "assume_role", "role_arn", jsonExpr(roleArn))
+			}
+
+			body["profile"] = jsonExpr(app.profileExpr(provider, accounts, providerMappings))
+			body["shared_credentials_files"] = []string{app.AwsCredentialsFile}
+			body["shared_config_files"] = []string{app.AwsSsoConfigFile}
+			// converted the way native providers are, constants are written as json values
+			attributes := hclwrite.NewEmptyFile().Body()
+			if _, ok := body["allowed_account_ids"]; (ok || !app.LosslessRewrite) && allowedAccountIdsExpr(provider) != "" {
+				attributes.SetAttributeRaw("allowed_account_ids", exprTokens(allowedAccountIdsExpr(provider)))
+			}
+			for _, attribute := range app.mappingAttributes(provider, providerMappings) {
+				attributes.SetAttributeRaw(attribute.Name, exprTokens(attribute.Expr))
+			}
+			for name, value := range pd.NativeBodyJSON(attributes) {
+				body[name] = value
+			}
+		} else if app.Verbose {
+			log.Printf("copying %s provider to overrides file", provider.Type)
+		}
+
+		providers = append(providers, pd.JSONObject{provider.Type: body})
+	}
+
+	pd.WriteJSONFile(app.Verbose, app.OverrideProviderFile, pd.JSONObject{"provider": providers})
+}
+
+/*
+providers declared in .tf.json or .tofu.json files can't be written back as native syntax, they're only carried over
+by --json. A json file only backed up for the backend --local-backend replaces is rewritten in place as json
+*/
+func (app Override) CheckProviderSyntax() {
+	if app.JSONOverrides {
+		return
+	}
+
+	providerFiles, backendFiles := pd.JSONBackupFiles(app.Verbose, app.LocalBackend)
+	for _, f := range providerFiles {
+		pd.Fail(fmt.Errorf("%s declares providers in json syntax, apply with --json to write %s.json", f, app.OverrideProviderFile))
+	}
+	for _, f := range backendFiles {
+		if app.Verbose {
+			log.Printf("%s declares its backend in json syntax, the local backend is written into it as json", f)
+		}
+	}
+}
+
+/*
+Write a terraform *_override.tf file that is merged into the existing aws provider blocks by alias, so no
+file in the module is moved or rewritten and restoring is deleting this one file.
+Terraform replaces nested blocks of the same type, an assume_role with an empty role_arn disables role assumption
+*/
+func (app Override) WriteNativeOverrideFile(ctx *hcl.EvalContext, providerMappings pd.OverrideConfig) {
+
+	if app.Verbose {
+		log.Println("writing native overrides file", app.NativeOverrideFile)
+	}
+
+	var config pd.ProviderConfig
+	if pd.IsTerragruntDir() {
+		config = pd.TerragruntProviders(app.Verbose, app.NativeOverrideFile, ctx)
+	} else {
+		config = pd.ParseProviderFile(app.Verbose, pd.ProviderFiles(app.Verbose), ctx)
+	}
+
+	accounts := app.resolveAccounts(ctx, config.Providers)
+
+	app.checkAllowedAccounts(config, accounts, providerMappings)
+
+	overrides := hclwrite.NewEmptyFile()
+
+	if app.LocalBackend {
+		terraform := overrides.Body().AppendNewBlock("terraform", nil)
+		terraform.Body().AppendNewBlock("backend", []string{"local"})
+		overrides.Body().AppendNewline()
+	}
+
+	for _, provider := range config.Providers {
+		if provider.Type != "aws" {
+			continue
+		}
+		region := app.regionOverrideExpr(provider, providerMappings)
+		var roleAttributes []pd.AssumeRoleAttribute
+		if provider.AssumeRole != nil {
+			roleAttributes = provider.AssumeRole.Attributes
+		}
+		app.appendNativeProviderOverride(overrides, provider.Alias, region, app.profileExpr(provider, accounts, providerMappings), allowedAccountIdsExpr(provider), app.mappingAttributes(provider, providerMappings), provider.AssumeRole != nil, app.readOnlyRoleArnExpr(provider), roleAttributes)
+	}
+
+	if app.JSONOverrides {
+		pd.WriteJSONFile(app.Verbose, app.NativeOverrideFile, pd.NativeBodyJSON(overrides.Body()))
+		return
+	}
+
+	err := os.WriteFile(app.NativeOverrideFile, overrides.Bytes(), 0644)
+	if err != nil {
+		if app.Verbose {
+			log.Println("error writing native overrides file", app.NativeOverrideFile)
+		}
+	}
+	check(err)
+}
+
+/*
+an aws provider block merged by terraform into the block with the same alias, an empty roleArn disables assume_role.
+region, profile, allowedAccountIds and roleArn are hcl expressions. region and allowed_account_ids are written when
+given, otherwise the original attributes are merged unchanged, attributes are the extra attributes of the mappings
+matching the provider. The override replaces the whole assume_role block, roleAttributes are the rest of the original
+written next to a read only roleArn
+*/
+func (app Override) appendNativeProviderOverride(overrides *hclwrite.File, providerAlias string, region string, profile string, allowedAccountIds string, attributes []mappingAttribute, assumesRole bool, roleArn string, roleAttributes []pd.AssumeRoleAttribute) {
+	body := overrides.Body().AppendNewBlock("provider", []string{"aws"}).Body()
+	if providerAlias != "" {
+		body.SetAttributeValue("alias", cty.StringVal(providerAlias))
+	}
+
+	if region != "" {
+		body.SetAttributeRaw("region", exprTokens(region))
+	}
+
+	body.SetAttributeRaw("profile", exprTokens(profile))
+	body.SetAttributeValue("shared_credentials_files", cty.ListVal([]cty.Value{cty.StringVal(app.AwsCredentialsFile)}))
+	body.SetAttributeValue("shared_config_files", cty.ListVal([]cty.Value{cty.StringVal(app.AwsSsoConfigFile)}))
+
+	if allowedAccountIds != "" {
+		body.SetAttributeRaw("allowed_account_ids", exprTokens(allowedAccountIds))
+	}
+
+	for _, attribute := range attributes {
+		body.SetAttributeRaw(attribute.Name, exprTokens(attribute.Expr))
+	}
+
+	if assumesRole {
+		assumeRole := body.AppendNewBlock("assume_role", nil).Body()
+		if roleArn == "" {
+			assumeRole.SetAttributeValue("role_arn", cty.StringVal(""))
+		} else {
+			assumeRole.SetAttributeRaw("role_arn", exprTokens(roleArn))
+			for _, attribute := range roleAttributes {
+				assumeRole.SetAttributeRaw(attribute.Name, exprTokens(attribute.Expr))
 			}
 		}
 	}
+
+	overrides.Body().AppendNewline()
+}
+
+/*
+Report every provider configuration of the local child modules and write a native override file into each module
+declaring an aws provider that assumes a role, legacy modules configure their own providers so the root overrides
+don't reach them. Providers passed in through configuration_aliases are the root providers and already overridden
+*/
+func (app Override) WriteModuleOverrides(providerMappings pd.OverrideConfig) {
+
+	if app.Verbose {
+		log.Println("searching child modules for provider configurations")
+	}
+
+	for _, dir := range pd.LocalModules(app.Verbose) {
+		overrides := hclwrite.NewEmptyFile()
+		overrides.Body().AppendUnstructuredTokens(hclwrite.Tokens{
+			{Type: hclsyntax.TokenComment, Bytes: []byte(pd.ManagedFileHeader + "\n")},
+		})
+		overridden := 0
+
+		for _, provider := range pd.ModuleProviders(app.Verbose, dir) {
+			name := provider.Type
+			if provider.Alias != "" {
+				name += "." + provider.Alias
+			}
+
+			switch {
+			case provider.ConfigurationAlias:
+				log.Printf("module %s expects provider %s from its caller", dir, name)
+			case provider.Type == "aws" && provider.AssumesRole:
+				log.Printf("module %s declares provider %s assuming a role, overriding", dir, name)
+				roleArn := ""
+				if app.ReadOnlyRole != "" {
+					roleArn = `"` + pd.ReadOnlyRoleArn(dir+" "+name, provider.RoleArn, app.ReadOnlyRole) + `"`
+				}
+				alias := provider.Alias
+				if alias == "" {
+					alias = "unaliased"
+				}
+				// the region of a module provider comes from its caller, only --region and an alias mapping replace it
+				region := app.overrideRegion(dir+" "+name, []string{alias}, true, providerMappings)
+				if region != "" {
+					region = quoted(region)
+				}
+				app.appendNativeProviderOverride(overrides, provider.Alias, region, quoted(app.setProviderProfile(alias, providerMappings)), "", nil, provider.AssumesRole, roleArn, provider.AssumeRoleAttributes)
+				overridden++
+			default:
+				log.Printf("module %s declares provider %s", dir, name)
+			}
+		}
+
+		if overridden == 0 {
+			continue
+		}
+
+		// child modules are always written in native syntax
+		moduleOverrideFile := filepath.Join(dir, strings.TrimSuffix(app.NativeOverrideFile, ".json"))
+		err := os.WriteFile(moduleOverrideFile, overrides.Bytes(), 0644)
+		if err != nil {
+			if app.Verbose {
+				log.Println("error writing module overrides file", moduleOverrideFile)
+			}
+		}
+		check(err)
+	}
 }
 
 func (app Override) rewriteAwsSsoConfig(file *os.File, defaultAwsProfileName string, defaultAwsSsoStartUrl string, defaultAwsRegion string, defaultAwsProfileAccountId string, defaultAwsProfileRole string) {
@@ -473,6 +810,9 @@ func (app *Override) Overrides() {
 	accountDataWithRoleData := aws.InterrogateRoles(accountDataWithoutRoleData, app.Client(), aws.CredentialsWorker, &app.Batch, &app.Verbose, app.UseCredentialsFile, &app.Workers)
 
 	app.Accounts = accountDataWithRoleData
+
+	// apply resolves account ids against this inventory without calling sso
+	aws.WriteAccountCache(app.Verbose, app.AccountCacheFile, app.Accounts)
 }
 
 func (app Override) ListProfiles() {
@@ -507,6 +847,63 @@ func (app *Override) SetAlias(a string) {
 	app.Alias = a
 }
 
+// profile placeholder values passed as name=value
+func (app *Override) SetPlaceholders(s []string) {
+	app.Placeholders = make(map[string]string)
+	for _, placeholder := range s {
+		name, value, ok := strings.Cut(placeholder, "=")
+		if !ok || name == "" {
+			pd.Fail(fmt.Errorf("--set %s is not a placeholder=value pair", placeholder))
+		}
+		app.Placeholders[strings.Trim(name, "{}")] = value
+	}
+}
+
 func (app *Override) UseAwsCredentialsFile(v bool) {
 	app.UseCredentialsFile = v
 }
+
+func (app *Override) SetVarFiles(f []string) {
+	app.VarFiles = f
+}
+
+func (app *Override) LosslessRewriting(v bool) {
+	app.LosslessRewrite = v
+}
+
+func (app *Override) SetStripAttributes(s []string) {
+	app.StripAttributes = s
+}
+
+func (app *Override) UseLocalBackend(v bool) {
+	app.LocalBackend = v
+}
+
+func (app *Override) UseNativeOverride(v bool) {
+	app.NativeOverride = v
+}
+
+// retarget every provider to one region, mappings.hcl regions and the regions of the provider blocks are ignored
+func (app *Override) SetRegion(r string) {
+	app.Region = r
+}
+
+func (app *Override) SetReadOnlyRole(r string) {
+	app.ReadOnlyRole = r
+}
+
+// overrides.tf becomes overrides.tf.json, the other override files are written as json the same way
+func (app *Override) UseJSONOverrides(v bool) {
+	app.JSONOverrides = v
+	app.OverrideProviderFile = strings.TrimSuffix(app.OverrideProviderFile, ".json")
+	app.NativeOverrideFile = strings.TrimSuffix(app.NativeOverrideFile, ".json")
+	app.BackendOverrideFile = strings.TrimSuffix(app.BackendOverrideFile, ".json")
+	app.RemoteStateOverrideFile = strings.TrimSuffix(app.RemoteStateOverrideFile, ".json")
+
+	if v {
+		app.OverrideProviderFile += ".json"
+		app.NativeOverrideFile += ".json"
+		app.BackendOverrideFile += ".json"
+		app.RemoteStateOverrideFile += ".json"
+	}
+}
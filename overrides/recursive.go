@@ -0,0 +1,184 @@
+package overrides
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	pd "github.com/b0bul/override/provider"
+)
+
+// a root module processed by a recursive apply or restore
+type rootResult struct {
+	Dir    string
+	Output []byte
+	Err    error
+	Files  []string // override files left in the directory afterwards
+}
+
+// the files apply writes into a root module
+func (app Override) overrideFiles() []string {
+	return []string{app.OverrideProviderFile, app.NativeOverrideFile, app.BackendOverrideFile, app.RemoteStateOverrideFile}
+}
+
+// override files and backups in a root module
+func (app Override) overridesIn(dir string) []string {
+	var files []string
+	for _, f := range app.overrideFiles() {
+		if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+			files = append(files, f)
+		}
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "*.overrides"))
+	check(err)
+	if len(backups) > 0 {
+		files = append(files, fmt.Sprintf("%d backup(s)", len(backups)))
+	}
+
+	return files
+}
+
+// the arguments of the command run in each root module, --recursive and --dir only apply to the command that starts them
+func ForwardedArgs(args []string) []string {
+	var forwarded []string
+
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			forwarded = append(forwarded, args[i])
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch name {
+		case "recursive":
+			continue
+		case "dir", "chdir":
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+		forwarded = append(forwarded, args[i])
+	}
+
+	return forwarded
+}
+
+// the last line logged by a root module, what it failed with
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	line := lines[len(lines)-1]
+
+	// drop the log timestamp, the summary is already ordered
+	if fields := strings.SplitN(line, " ", 3); len(fields) == 3 && strings.Count(fields[0], "/") == 2 {
+		return fields[2]
+	}
+	return line
+}
+
+/*
+run apply or restore in every root module below root, Workers at a time. Each root module runs in its own process
+with its directory as the working directory, every function in provider works on "." and a configuration error exits
+the process, so one module failing never affects the others. A summary of every module is printed once all have run
+*/
+func (app Override) Recursive(command string, root string, args []string) {
+	roots := pd.RootModules(app.Verbose, root, app.overrideFiles())
+	if len(roots) == 0 {
+		log.Fatalln("no root modules found below", root)
+	}
+
+	log.Printf("running %s in %d root modules below %s", command, len(roots), root)
+
+	executable, err := os.Executable()
+	check(err)
+
+	numberOfWorkers := app.Workers
+	if numberOfWorkers < 1 {
+		numberOfWorkers = 1
+	}
+
+	queue := make(chan string)
+	results := make(chan rootResult)
+
+	var workers sync.WaitGroup
+	for id := 0; id < numberOfWorkers; id++ {
+		workers.Add(1)
+		go func(id int) {
+			defer workers.Done()
+			for dir := range queue {
+				if app.Verbose {
+					log.Printf("[worker-%d] %s %s", id, command, dir)
+				}
+
+				cmd := exec.Command(executable, append([]string{command}, args...)...)
+				cmd.Dir = dir
+				output, err := cmd.CombinedOutput()
+				results <- rootResult{dir, output, err, app.overridesIn(dir)}
+			}
+		}(id)
+	}
+
+	go func() {
+		for _, dir := range roots {
+			queue <- dir
+		}
+		close(queue)
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	var summary []rootResult
+	for result := range results {
+		// output of a module is printed as a whole so modules running at once don't interleave
+		if app.Verbose || result.Err != nil {
+			fmt.Printf("--- %s\n%s", result.Dir, result.Output)
+		}
+		summary = append(summary, result)
+	}
+
+	sort.Slice(summary, func(i, j int) bool {
+		return summary[i].Dir < summary[j].Dir
+	})
+
+	width := 0
+	for _, result := range summary {
+		if len(result.Dir) > width {
+			width = len(result.Dir)
+		}
+	}
+
+	failed := 0
+	fmt.Println()
+	for _, result := range summary {
+		switch {
+		case result.Err != nil:
+			failed++
+			detail := lastLine(result.Output)
+			if detail == "" {
+				detail = result.Err.Error()
+			}
+			fmt.Printf("failed  %-*s  %s\n", width, result.Dir, detail)
+		case len(result.Files) == 0 && command == "restore":
+			fmt.Printf("ok      %-*s  restored\n", width, result.Dir)
+		case len(result.Files) == 0:
+			fmt.Printf("ok      %-*s  nothing overridden\n", width, result.Dir)
+		default:
+			fmt.Printf("ok      %-*s  %s\n", width, result.Dir, strings.Join(result.Files, ", "))
+		}
+	}
+	fmt.Println()
+
+	if failed > 0 {
+		log.Fatalf("%s failed in %d of %d root modules", command, failed, len(summary))
+	}
+	log.Printf("%s done in %d root modules", command, len(summary))
+}
@@ -0,0 +1,80 @@
+package overrides
+
+import (
+	"log"
+
+	pd "github.com/b0bul/override/provider"
+)
+
+/*
+the region an aws provider is retargeted to, --region retargets every provider, a region in the mapping for the alias
+matching the provider replaces its region and a region in the default mapping is only used by providers whose region
+is unset or only known at plan. aliases are the mappings.hcl labels of the provider, most specific first. Empty when the
+provider keeps its own region
+*/
+func (app Override) overrideRegion(name string, aliases []string, regionKnown bool, providerMappings pd.OverrideConfig) string {
+	if app.Region != "" {
+		if app.Verbose {
+			log.Printf("provider %s region %s from --region", name, app.Region)
+		}
+		return app.Region
+	}
+
+	resolved := resolveMapping(aliases, providerMappings)
+
+	if resolved.region == "" || (resolved.regionDefault && regionKnown) {
+		return ""
+	}
+
+	if app.Verbose {
+		log.Printf("provider %s region %s from mappings.hcl", name, resolved.region)
+	}
+	return resolved.region
+}
+
+// the region written for an aws provider as an hcl expression, empty when the provider has none
+func (app Override) providerRegionExpr(provider pd.AwsProviderConfigBody, providerMappings pd.OverrideConfig) string {
+	region := app.overrideRegion(providerName(provider), mappingAliases(provider), provider.Region != "", providerMappings)
+	if region == "" {
+		region = provider.Region
+	}
+
+	switch {
+	case region != "":
+		return `"` + region + `"`
+	case provider.RegionSource != "":
+		log.Printf("provider %s region %s is only known at plan, written as is", providerName(provider), provider.RegionSource)
+		return provider.RegionSource
+	}
+	return ""
+}
+
+// the region of every instance of a provider as an hcl expression, empty when the provider has no region
+func (app Override) regionExpr(provider pd.AwsProviderConfigBody, providerMappings pd.OverrideConfig) string {
+	return instanceExpr(provider, func(instance pd.AwsProviderConfigBody) string {
+		// a region from each.value is kept as written unless the instance is retargeted
+		if instance.InstanceKey != "" && provider.RegionSource != "" && app.overrideRegion(providerName(instance), mappingAliases(instance), true, providerMappings) == "" {
+			return provider.RegionSource
+		}
+		return app.providerRegionExpr(instance, providerMappings)
+	})
+}
+
+// the region expression of a provider when any of its instances is retargeted, empty when every instance keeps its region
+func (app Override) regionOverrideExpr(provider pd.AwsProviderConfigBody, providerMappings pd.OverrideConfig) string {
+	for _, instance := range providerInstances(provider) {
+		if app.overrideRegion(providerName(instance), mappingAliases(instance), instance.Region != "", providerMappings) != "" {
+			return app.regionExpr(provider, providerMappings)
+		}
+	}
+	return ""
+}
+
+// region line of a generated provider, empty when the provider has no region
+func (app Override) regionAttribute(provider pd.AwsProviderConfigBody, providerMappings pd.OverrideConfig) string {
+	expr := app.regionExpr(provider, providerMappings)
+	if expr == "" {
+		return ""
+	}
+	return "\tregion = " + expr + "\n"
+}
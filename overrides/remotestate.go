@@ -0,0 +1,110 @@
+package overrides
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	pd "github.com/b0bul/override/provider"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// the profile of a terraform_remote_state data source, its own mapping first then default. Empty when neither is mapped
+func remoteStateProfile(name string, providerMappings pd.OverrideConfig) string {
+	profiles := make(map[string]string)
+	for _, mapping := range providerMappings.RemoteStates {
+		profiles[mapping.Name] = mapping.Profile
+	}
+
+	if profile, ok := profiles[name]; ok {
+		return profile
+	}
+	return profiles["default"]
+}
+
+// the config object of a data source with its credentials replaced by profile in native syntax, one line for json
+func (app Override) remoteStateConfig(state pd.RemoteState, profile string) string {
+	strip := make(map[string]bool)
+	for _, name := range pd.BackendCredentialAttributes {
+		strip[name] = true
+	}
+
+	var items []string
+	for _, item := range state.Config {
+		if strip[item.Key] {
+			if app.Verbose {
+				log.Printf("stripping %s config attribute %s", state.Address(), item.Key)
+			}
+			continue
+		}
+		items = append(items, fmt.Sprintf("%s = %s", item.Key, item.Expr))
+	}
+	items = append(items,
+		fmt.Sprintf("profile = %s", quoted(profile)),
+		fmt.Sprintf("shared_credentials_files = [%s]", quoted(app.AwsCredentialsFile)),
+		fmt.Sprintf("shared_config_files = [%s]", quoted(app.AwsSsoConfigFile)),
+	)
+
+	if app.JSONOverrides {
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+	return "{\n" + strings.Join(items, "\n") + "\n}"
+}
+
+/*
+Write a remote_state_override.tf giving every terraform_remote_state data source reading an s3 backend the profile
+mapped to it in mappings.hcl. Terraform replaces config as a whole so every other item is copied as written and only
+the credentials, role_arn, assume_role and profile, are swapped. Data sources without a mapping are left as they are
+*/
+func (app Override) WriteRemoteStateOverrideFile(providerMappings pd.OverrideConfig) {
+	if len(providerMappings.RemoteStates) == 0 {
+		if app.Verbose {
+			log.Println("no remote_state mappings in", app.MappingFile, "skipping remote state overrides")
+		}
+		return
+	}
+
+	overrides := hclwrite.NewEmptyFile()
+	overrides.Body().AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenComment, Bytes: []byte(pd.ManagedFileHeader + "\n")},
+	})
+	overridden := 0
+
+	for _, state := range pd.RemoteStates(app.Verbose) {
+		profile := remoteStateProfile(state.Name, providerMappings)
+		if profile == "" {
+			log.Printf("%s has no remote_state mapping, leaving its credentials", state.Address())
+			continue
+		}
+
+		log.Printf("overriding %s with profile %s", state.Address(), profile)
+
+		body := overrides.Body().AppendNewBlock("data", []string{"terraform_remote_state", state.Name}).Body()
+		body.SetAttributeRaw("config", exprTokens(app.remoteStateConfig(state, profile)))
+		overrides.Body().AppendNewline()
+		overridden++
+	}
+
+	if overridden == 0 {
+		return
+	}
+
+	pd.CheckManagedFile(app.RemoteStateOverrideFile)
+
+	if app.JSONOverrides {
+		config := pd.NativeBodyJSON(overrides.Body())
+		config[pd.JSONCommentKey] = pd.ManagedFileComment
+		pd.WriteJSONFile(app.Verbose, app.RemoteStateOverrideFile, config)
+		return
+	}
+
+	err := os.WriteFile(app.RemoteStateOverrideFile, hclwrite.Format(overrides.Bytes()), 0644)
+	if err != nil {
+		if app.Verbose {
+			log.Println("error writing remote state overrides file", app.RemoteStateOverrideFile)
+		}
+	}
+	check(err)
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+/*
+fill in AllowedAccountIds, evaluated when locals and variables are enough to know them, otherwise
+AllowedAccountIdsSource keeps the expression in native syntax so a variable without a value is still resolved by
+terraform at plan time. Anything known that isn't a list of strings is reported the way decoding reports it
+*/
+func setProviderAllowedAccountIds(provider *AwsProviderConfigBody, src []byte, json bool, ctx *hcl.EvalContext) hcl.Diagnostics {
+	if provider.AllowedAccountIdsAttribute == nil {
+		return nil
+	}
+
+	expr := provider.AllowedAccountIdsAttribute.Expr
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() || !value.IsWhollyKnown() {
+		provider.AllowedAccountIdsSource = expressionSource(expr, src, json)
+		return nil
+	}
+	if value.IsNull() {
+		return nil
+	}
+
+	var ids []string
+	value, err := convert.Convert(value, cty.List(cty.String))
+	if err == nil {
+		err = gocty.FromCtyValue(value, &ids)
+	}
+	if err != nil {
+		subject := expr.Range()
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unsuitable value type",
+			Detail:   "Unsuitable value: list of string required",
+			Subject:  &subject,
+		}}
+	}
+
+	// declared empty is kept apart from unset so it's still written, the aws provider takes it as no restriction
+	if ids == nil {
+		ids = []string{}
+	}
+	provider.AllowedAccountIds = ids
+	return nil
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+const s3Backend string = "s3"
+
+// json files can't hold comments, a "//" property is the comment terraform ignores in json syntax
+const JSONCommentKey string = "//"
+
+// credentials of the s3 backend, used for the dynamodb lock table too, replaced by the mapped profile
+var BackendCredentialAttributes = []string{
+	"profile", "role_arn", "assume_role", "assume_role_with_web_identity", "session_name", "external_id",
+	"assume_role_duration_seconds", "assume_role_policy", "assume_role_policy_arns", "assume_role_tags",
+	"assume_role_transitive_tag_keys", "access_key", "secret_key", "token", "shared_credentials_file",
+	"shared_credentials_files", "shared_config_files",
+}
+
+// the s3 backend of the root module, Block is set when it's declared in native syntax and JSON when in json syntax
+type S3Backend struct {
+	File  string
+	Block *hclwrite.Block
+	JSON  JSONObject
+}
+
+/*
+find the s3 backend of the root module, read from the files as they are now since apply only ever moves provider
+blocks. nil when there's no backend or it isn't s3, the credentials of other backends aren't known
+*/
+func FindS3Backend(verbose bool) *S3Backend {
+	for _, f := range terraformFiles(verbose, ".") {
+		src, err := os.ReadFile(f)
+		check(err)
+
+		if isJSONFile(f) {
+			for _, terraform := range jsonBlocks(decodeJSONObject(src, f)["terraform"]) {
+				for _, backend := range jsonBlocks(terraform["backend"]) {
+					for backendType, body := range backend {
+						if backendType != s3Backend {
+							log.Printf("%s declares a %s backend, only s3 backends are overridden", f, backendType)
+							return nil
+						}
+						if bodies := jsonBlocks(body); len(bodies) > 0 {
+							return &S3Backend{File: f, JSON: bodies[0]}
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		file, diags := hclwrite.ParseConfig(src, f, hcl.InitialPos)
+		if diags.HasErrors() {
+			reportFileDiagnostics(f, src, diags)
+		}
+
+		for _, terraform := range TerraformBlocks(file) {
+			for _, block := range terraform.Body().Blocks() {
+				if block.Type() != "backend" {
+					continue
+				}
+				if len(block.Labels()) == 0 || block.Labels()[0] != s3Backend {
+					log.Printf("%s declares a %v backend, only s3 backends are overridden", f, block.Labels())
+					return nil
+				}
+				return &S3Backend{File: f, Block: block}
+			}
+		}
+	}
+
+	if verbose {
+		log.Println("no backend declared, skipping backend override")
+	}
+	return nil
+}
+
+// files written by this app start with the managed header, or carry it as the json comment
+func isManagedFile(src []byte, filename string) bool {
+	if isJSONFile(filename) {
+		comment, _ := decodeJSONObject(src, filename)[JSONCommentKey].(string)
+		return comment == ManagedFileComment
+	}
+	return strings.HasPrefix(string(src), ManagedFileHeader)
+}
+
+// fail rather than overwrite an override file this app didn't write, either syntax
+func CheckManagedFile(overrideFile string) {
+	native := strings.TrimSuffix(overrideFile, jsonFileExtension)
+
+	for _, f := range []string{native, native + jsonFileExtension} {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if !isManagedFile(src, f) {
+			Fail(fmt.Errorf("%s already exists and was not written by overrides, move it aside before applying", f))
+		}
+	}
+}
+
+// Remove an override file in either syntax, only when it was written by this app
+func RemoveManagedOverrideFile(verboseLogging bool, overrideFile string) {
+	native := strings.TrimSuffix(overrideFile, jsonFileExtension)
+
+	for _, f := range []string{native, native + jsonFileExtension} {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			if verboseLogging {
+				log.Printf("no %s file detected, skipping cleanup", f)
+			}
+			continue
+		}
+
+		if !isManagedFile(src, f) {
+			if verboseLogging {
+				log.Printf("%s was not written by overrides, leaving it in place", f)
+			}
+			continue
+		}
+
+		if verboseLogging {
+			log.Println("cleaning up previous:", f)
+		}
+		check(os.Remove(f))
+	}
+}
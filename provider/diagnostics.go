@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// run once before exiting on any error so a failed apply never leaves the working directory half overridden
+var rollback func()
+
+// register how to put the working directory back if apply fails part way through
+func RollbackOnError(f func()) {
+	rollback = f
+}
+
+// roll back the working directory, if registered, then exit. An error while rolling back exits straight away
+// exported for checks made outside of this package, such as the account pre-flight
+func Fail(err error) {
+	if rollback != nil {
+		restore := rollback
+		rollback = nil
+		log.Println("rolling back working directory")
+		restore()
+	}
+	log.Fatalln(err)
+}
+
+// diagnostics collected across every file parsed, kept with the source of each file for snippets
+type fileDiagnostics struct {
+	files map[string]*hcl.File
+	diags hcl.Diagnostics
+}
+
+func newFileDiagnostics() *fileDiagnostics {
+	return &fileDiagnostics{files: make(map[string]*hcl.File)}
+}
+
+// filename is the name diagnostics refer to, which for a backup is the original file. file is nil when unreadable
+func (d *fileDiagnostics) add(filename string, file *hcl.File, diags hcl.Diagnostics) {
+	if len(diags) == 0 {
+		return
+	}
+	if _, ok := d.files[filename]; !ok && file != nil {
+		d.files[filename] = file
+	}
+	d.diags = append(d.diags, diags...)
+}
+
+// report the diagnostics of a single file, src is its source for snippets. See report
+func reportFileDiagnostics(filename string, src []byte, diags hcl.Diagnostics) {
+	diagnostics := newFileDiagnostics()
+	diagnostics.add(filename, &hcl.File{Bytes: src}, diags)
+	diagnostics.report()
+}
+
+/*
+print every diagnostic with its file, line, column and a source snippet the way terraform validate does, then
+roll back and exit. Nothing is printed unless there's at least one error
+*/
+func (d *fileDiagnostics) report() {
+	if !d.diags.HasErrors() {
+		return
+	}
+
+	writer := hcl.NewDiagnosticTextWriter(os.Stderr, d.files, 0, false)
+	err := writer.WriteDiagnostics(d.diags)
+	if err != nil {
+		log.Println("error writing diagnostics", err)
+	}
+
+	errors := 0
+	for _, diag := range d.diags {
+		if diag.Severity == hcl.DiagError {
+			errors++
+		}
+	}
+
+	Fail(fmt.Errorf("configuration has %d error(s)", errors))
+}
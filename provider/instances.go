@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+/*
+expand the for_each of an opentofu provider into an instance per key with each.key and each.value evaluated, so
+region, allowed_account_ids and role_arn can be known per instance. A for_each only known at plan leaves the
+provider without instances and it's overridden as a whole
+*/
+func expandProviderInstances(provider *AwsProviderConfigBody, src []byte, json bool, ctx *hcl.EvalContext) hcl.Diagnostics {
+	if provider.ForEach == nil {
+		return nil
+	}
+
+	expr := provider.ForEach.Expr
+	provider.ForEachSource = expressionSource(expr, src, json)
+
+	forEach, diags := expr.Value(ctx)
+	if diags.HasErrors() || !forEach.IsWhollyKnown() || forEach.IsNull() {
+		return nil
+	}
+
+	forEachType := forEach.Type()
+	if !forEachType.IsMapType() && !forEachType.IsObjectType() && !(forEachType.IsSetType() && forEachType.ElementType() == cty.String) {
+		subject := expr.Range()
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid for_each argument",
+			Detail:   fmt.Sprintf("The for_each of a provider must be a map or a set of strings, not %s.", forEachType.FriendlyName()),
+			Subject:  &subject,
+		}}
+	}
+
+	for it := forEach.ElementIterator(); it.Next(); {
+		key, value := it.Element()
+
+		instanceCtx := ctx.NewChild()
+		instanceCtx.Variables = map[string]cty.Value{
+			"each": cty.ObjectVal(map[string]cty.Value{"key": key, "value": value}),
+		}
+
+		instance := *provider
+		instance.Instances = nil
+		instance.InstanceKey = key.AsString()
+		instance.Region, instance.RegionSource = "", ""
+		setProviderRegion(&instance, src, json, instanceCtx)
+		instance.AllowedAccountIds, instance.AllowedAccountIdsSource = nil, ""
+		setProviderAllowedAccountIds(&instance, src, json, instanceCtx)
+
+		if provider.AssumeRole != nil && provider.AssumeRole.RoleArn != nil {
+			assumeRole := *provider.AssumeRole
+			assumeRole.Template = roleArnTemplate(assumeRole.RoleArn.Expr, src, instanceCtx)
+			instance.AssumeRole = &assumeRole
+		}
+
+		provider.Instances = append(provider.Instances, instance)
+	}
+
+	return nil
+}
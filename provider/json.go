@@ -0,0 +1,302 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+const tfJsonFileExtension string = ".tf.json"
+const tofuJsonFileExtension string = ".tofu.json"
+
+// a block body in terraform json syntax, nested blocks are objects keyed by type then label
+type JSONObject = map[string]interface{}
+
+func isJSONFile(name string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(name, providerBackupFileExtension), jsonFileExtension)
+}
+
+// parse a module file with the syntax its name implies, json bodies decode against the same schemas
+func parseTerraformFile(parser *hclparse.Parser, filename string) (*hcl.File, hcl.Diagnostics) {
+	if isJSONFile(filename) {
+		return parser.ParseJSONFile(filename)
+	}
+	return parser.ParseHCLFile(filename)
+}
+
+func ProviderKey(providerType string, alias string) string {
+	return providerType + "." + alias
+}
+
+func decodeJSONObject(src []byte, filename string) JSONObject {
+	var object JSONObject
+
+	decoder := json.NewDecoder(bytes.NewReader(src))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&object); err != nil {
+		check(fmt.Errorf("%s: %w", filename, err))
+	}
+	return object
+}
+
+func encodeJSONObject(object JSONObject) []byte {
+	src, err := json.MarshalIndent(object, "", "  ")
+	check(err)
+	return append(src, '\n')
+}
+
+// a block type can be a single object or an array of objects, both are returned as a slice
+func jsonBlocks(value interface{}) []JSONObject {
+	switch v := value.(type) {
+	case JSONObject:
+		return []JSONObject{v}
+	case []interface{}:
+		var blocks []JSONObject
+		for _, item := range v {
+			blocks = append(blocks, jsonBlocks(item)...)
+		}
+		return blocks
+	}
+	return nil
+}
+
+type jsonProvider struct {
+	Type string
+	Body JSONObject
+}
+
+// "provider": {"aws": [{...}, {...}]} or "provider": [{"aws": {...}}], each labelled body in order of type
+func jsonProviders(config JSONObject) []jsonProvider {
+	var providers []jsonProvider
+	for _, byType := range jsonBlocks(config["provider"]) {
+		for providerType, bodies := range byType {
+			for _, body := range jsonBlocks(bodies) {
+				providers = append(providers, jsonProvider{providerType, body})
+			}
+		}
+	}
+	return providers
+}
+
+func hasJSONBackend(config JSONObject) bool {
+	for _, terraform := range jsonBlocks(config["terraform"]) {
+		if _, ok := terraform["backend"]; ok {
+			return true
+		}
+		if _, ok := terraform["cloud"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// json equivalent of neutraliseProviders, key order isn't kept but the backup restores the original bytes
+func neutraliseJSONProviders(src []byte, filename string, localBackend bool) []byte {
+	config := decodeJSONObject(src, filename)
+
+	delete(config, "provider")
+
+	if localBackend {
+		for _, terraform := range jsonBlocks(config["terraform"]) {
+			if _, ok := terraform["backend"]; !ok {
+				if _, ok := terraform["cloud"]; !ok {
+					continue
+				}
+			}
+			delete(terraform, "cloud")
+			terraform["backend"] = JSONObject{"local": JSONObject{}}
+		}
+	}
+
+	return encodeJSONObject(config)
+}
+
+// json equivalent of the edited file restore, the provider and terraform keys of the backup are put back
+func spliceJSONProviders(current []byte, original string, backupSrc []byte, backup string) []byte {
+	backupConfig := decodeJSONObject(backupSrc, backup)
+	currentConfig := decodeJSONObject(current, original)
+
+	if hasJSONBackend(backupConfig) {
+		currentConfig["terraform"] = backupConfig["terraform"]
+	}
+	currentConfig["provider"] = backupConfig["provider"]
+
+	return encodeJSONObject(currentConfig)
+}
+
+// number of provider blocks and whether a backend is declared, in either syntax
+func inspectFile(src []byte, filename string) (int, bool, hcl.Diagnostics) {
+	if isJSONFile(filename) {
+		file, diags := hcljson.Parse(src, filename)
+		if diags.HasErrors() {
+			return 0, false, diags
+		}
+
+		config := decodeJSONObject(file.Bytes, filename)
+		return len(jsonProviders(config)), hasJSONBackend(config), nil
+	}
+
+	file, diags := hclwrite.ParseConfig(src, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return 0, false, diags
+	}
+	return len(ProviderBlocks(file)), hasBackend(file), nil
+}
+
+// the json encoding of a constant expression, strings that would read as a template in json syntax are not constant
+func constantJSON(exprSrc []byte) (json.RawMessage, bool) {
+	expr, diags := hclsyntax.ParseExpression(exprSrc, "", hcl.InitialPos)
+	if diags.HasErrors() || len(expr.Variables()) > 0 {
+		return nil, false
+	}
+
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() || !value.IsWhollyKnown() {
+		return nil, false
+	}
+
+	// a bare null has no type to marshal with
+	if value.IsNull() {
+		return json.RawMessage("null"), true
+	}
+
+	encoded, err := ctyjson.SimpleJSONValue{Value: value}.MarshalJSON()
+	if err != nil || bytes.Contains(encoded, []byte("${")) || bytes.Contains(encoded, []byte("%{")) {
+		return nil, false
+	}
+	return encoded, true
+}
+
+/*
+convert a native syntax body to json syntax without evaluating it, constants are written as json values and any
+other expression as a "${...}" template of its source, which terraform evaluates exactly as the native expression.
+Given the body of a whole file this is the json form of the file
+*/
+func NativeBodyJSON(body *hclwrite.Body) JSONObject {
+	object := JSONObject{}
+
+	for name, attribute := range body.Attributes() {
+		exprSrc := bytes.TrimSpace(attribute.Expr().BuildTokens(nil).Bytes())
+
+		if encoded, ok := constantJSON(exprSrc); ok {
+			object[name] = encoded
+			continue
+		}
+
+		object[name] = "${" + string(exprSrc) + "}"
+	}
+
+	for _, block := range body.Blocks() {
+		var nested interface{} = NativeBodyJSON(block.Body())
+		labels := block.Labels()
+		for i := len(labels) - 1; i >= 0; i-- {
+			nested = JSONObject{labels[i]: nested}
+		}
+
+		existing, ok := object[block.Type()].([]interface{})
+		if !ok {
+			existing = []interface{}{}
+		}
+		object[block.Type()] = append(existing, nested)
+	}
+
+	return object
+}
+
+/*
+json bodies of every provider keyed by ProviderKey, native blocks are converted and json bodies are taken as written.
+The alias of a native block is read from its literal, terraform doesn't allow alias to be an expression
+*/
+func ProviderJSONBodies(verboseLogging bool, backedupProviderFiles []string) map[string]JSONObject {
+	bodies := make(map[string]JSONObject)
+
+	for _, backup := range backedupProviderFiles {
+		src, err := os.ReadFile(backup)
+		check(err)
+
+		if isJSONFile(backup) {
+			for _, provider := range jsonProviders(decodeJSONObject(src, backup)) {
+				alias, _ := provider.Body["alias"].(string)
+				bodies[ProviderKey(provider.Type, alias)] = provider.Body
+			}
+			continue
+		}
+
+		if verboseLogging {
+			log.Println("converting provider blocks to json", backup)
+		}
+
+		file, diags := hclwrite.ParseConfig(src, backup, hcl.InitialPos)
+		if diags.HasErrors() {
+			reportFileDiagnostics(backup, src, diags)
+		}
+
+		for _, block := range ProviderBlocks(file) {
+			body := NativeBodyJSON(block.Body())
+
+			alias := ""
+			if raw, ok := body["alias"].(json.RawMessage); ok {
+				json.Unmarshal(raw, &alias)
+			}
+			bodies[ProviderKey(block.Labels()[0], alias)] = body
+		}
+	}
+
+	return bodies
+}
+
+// json equivalent of ExtractDefaultTags for one provider, tags that can't be evaluated keep the original tags value
+func DefaultTagsJSON(provider AwsProviderConfigBody, original JSONObject) interface{} {
+	if !hasTags(provider) {
+		return JSONObject{}
+	}
+
+	tags := provider.DefaultTags.Tags
+	if tags.IsWhollyKnown() {
+		if encoded, err := (ctyjson.SimpleJSONValue{Value: tags}).MarshalJSON(); err == nil {
+			return json.RawMessage(encoded)
+		}
+	}
+
+	for _, defaultTags := range jsonBlocks(original["default_tags"]) {
+		if value, ok := defaultTags["tags"]; ok {
+			return value
+		}
+	}
+	return JSONObject{}
+}
+
+// set an attribute of a nested block written as an object or an array of objects, the first block is used
+func SetJSONBlockAttribute(body JSONObject, blockType string, name string, value interface{}) {
+	for _, block := range jsonBlocks(body[blockType]) {
+		block[name] = value
+		return
+	}
+}
+
+// write a terraform json file, provider blocks are written as an array so they keep their order
+func WriteJSONFile(verboseLogging bool, filename string, config JSONObject) {
+	if verboseLogging {
+		log.Println("writing json file", filename)
+	}
+
+	err := os.WriteFile(filename, encodeJSONObject(config), 0644)
+	if err != nil {
+		if verboseLogging {
+			log.Println("error writing json file", filename)
+		}
+		check(err)
+	}
+}
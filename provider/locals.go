@@ -0,0 +1,215 @@
+package provider
+
+import (
+	"log"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/tryfunc"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// the subset of terraform built-in functions that can be evaluated without a plan, filesystem or provider
+// anything outside of this table is reported as an unknown function and the local depending on it is left unknown
+func terraformFunctions() map[string]function.Function {
+	return map[string]function.Function{
+		"abs":             stdlib.AbsoluteFunc,
+		"can":             tryfunc.CanFunc,
+		"ceil":            stdlib.CeilFunc,
+		"chomp":           stdlib.ChompFunc,
+		"chunklist":       stdlib.ChunklistFunc,
+		"coalesce":        stdlib.CoalesceFunc,
+		"coalescelist":    stdlib.CoalesceListFunc,
+		"compact":         stdlib.CompactFunc,
+		"concat":          stdlib.ConcatFunc,
+		"contains":        stdlib.ContainsFunc,
+		"csvdecode":       stdlib.CSVDecodeFunc,
+		"distinct":        stdlib.DistinctFunc,
+		"element":         stdlib.ElementFunc,
+		"flatten":         stdlib.FlattenFunc,
+		"floor":           stdlib.FloorFunc,
+		"format":          stdlib.FormatFunc,
+		"formatdate":      stdlib.FormatDateFunc,
+		"formatlist":      stdlib.FormatListFunc,
+		"indent":          stdlib.IndentFunc,
+		"index":           stdlib.IndexFunc,
+		"join":            stdlib.JoinFunc,
+		"jsondecode":      stdlib.JSONDecodeFunc,
+		"jsonencode":      stdlib.JSONEncodeFunc,
+		"keys":            stdlib.KeysFunc,
+		"length":          stdlib.LengthFunc,
+		"log":             stdlib.LogFunc,
+		"lookup":          stdlib.LookupFunc,
+		"lower":           stdlib.LowerFunc,
+		"max":             stdlib.MaxFunc,
+		"merge":           stdlib.MergeFunc,
+		"min":             stdlib.MinFunc,
+		"parseint":        stdlib.ParseIntFunc,
+		"pow":             stdlib.PowFunc,
+		"range":           stdlib.RangeFunc,
+		"regex":           stdlib.RegexFunc,
+		"regexall":        stdlib.RegexAllFunc,
+		"replace":         stdlib.ReplaceFunc,
+		"reverse":         stdlib.ReverseListFunc,
+		"setintersection": stdlib.SetIntersectionFunc,
+		"setproduct":      stdlib.SetProductFunc,
+		"setsubtract":     stdlib.SetSubtractFunc,
+		"setunion":        stdlib.SetUnionFunc,
+		"signum":          stdlib.SignumFunc,
+		"slice":           stdlib.SliceFunc,
+		"sort":            stdlib.SortFunc,
+		"split":           stdlib.SplitFunc,
+		"strrev":          stdlib.ReverseFunc,
+		"substr":          stdlib.SubstrFunc,
+		"timeadd":         stdlib.TimeAddFunc,
+		"title":           stdlib.TitleFunc,
+		"tobool":          stdlib.MakeToFunc(cty.Bool),
+		"tolist":          stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+		"tomap":           stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+		"tonumber":        stdlib.MakeToFunc(cty.Number),
+		"toset":           stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+		"tostring":        stdlib.MakeToFunc(cty.String),
+		"trim":            stdlib.TrimFunc,
+		"trimprefix":      stdlib.TrimPrefixFunc,
+		"trimspace":       stdlib.TrimSpaceFunc,
+		"trimsuffix":      stdlib.TrimSuffixFunc,
+		"try":             tryfunc.TryFunc,
+		"upper":           stdlib.UpperFunc,
+		"values":          stdlib.ValuesFunc,
+		"zipmap":          stdlib.ZipmapFunc,
+	}
+}
+
+// collect every local value definition across all locals blocks, terraform rejects duplicates so only the first is kept
+func collectLocals(verbose bool, blocks []LocalsBlock) map[string]hcl.Expression {
+	definitions := make(map[string]hcl.Expression)
+	diagnostics := newFileDiagnostics()
+
+	for _, block := range blocks {
+		for _, values := range block.Locals {
+			attributes, diags := values.Config.JustAttributes()
+			diagnostics.add(block.Filename, block.File, diags)
+			if diags.HasErrors() {
+				if verbose {
+					log.Println("error reading locals block attributes in", block.Filename)
+				}
+				continue
+			}
+
+			for name, attribute := range attributes {
+				if _, ok := definitions[name]; ok {
+					if verbose {
+						log.Printf("duplicate local %s defined at %s, keeping first definition", name, attribute.Range)
+					}
+					continue
+				}
+				definitions[name] = attribute.Expr
+			}
+		}
+	}
+
+	diagnostics.report()
+
+	return definitions
+}
+
+// names of the locals an expression depends on
+func localDependencies(expr hcl.Expression) []string {
+	var dependencies []string
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		if attr, ok := traversal[1].(hcl.TraverseAttr); ok {
+			dependencies = append(dependencies, attr.Name)
+		}
+	}
+	return dependencies
+}
+
+// build a context for an expression, anything outside of local and var such as data, module or resource references are unknown
+func localsEvalContext(expr hcl.Expression, resolved map[string]cty.Value, inputVariables cty.Value, functions map[string]function.Function) *hcl.EvalContext {
+	variables := map[string]cty.Value{
+		"local": cty.ObjectVal(resolved),
+		"var":   inputVariables,
+	}
+
+	for _, traversal := range expr.Variables() {
+		if _, ok := variables[traversal.RootName()]; !ok {
+			variables[traversal.RootName()] = cty.DynamicVal
+		}
+	}
+
+	return &hcl.EvalContext{
+		Variables: variables,
+		Functions: functions,
+	}
+}
+
+/*
+locals are evaluated as a dependency graph, a local is evaluated once every local it references has a value
+each pass resolves at least one local until none are left, whatever remains is either a cycle or references a local
+that doesn't exist and is set to unknown. Locals that fail to evaluate, for example calling file() or another
+function outside of functions, are also set to unknown rather than failing the run
+*/
+func evaluateLocals(verbose bool, blocks []LocalsBlock, inputVariables cty.Value, functions map[string]function.Function) map[string]cty.Value {
+
+	if verbose {
+		log.Println("evaluating locals")
+	}
+
+	pending := collectLocals(verbose, blocks)
+	resolved := make(map[string]cty.Value)
+
+	names := make([]string, 0, len(pending))
+	for name := range pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+
+		for _, name := range names {
+			expr, ok := pending[name]
+			if !ok {
+				continue
+			}
+
+			ready := true
+			for _, dependency := range localDependencies(expr) {
+				if _, ok := resolved[dependency]; !ok {
+					ready = false
+					break
+				}
+			}
+
+			if !ready {
+				continue
+			}
+
+			value, diags := expr.Value(localsEvalContext(expr, resolved, inputVariables, functions))
+			if diags.HasErrors() {
+				if verbose {
+					log.Printf("local.%s could not be evaluated, setting unknown: %s", name, diags.Error())
+				}
+				value = cty.DynamicVal
+			}
+
+			resolved[name] = value
+			delete(pending, name)
+			progress = true
+		}
+	}
+
+	for name := range pending {
+		if verbose {
+			log.Printf("local.%s is part of a cycle or references an undefined local, setting unknown", name)
+		}
+		resolved[name] = cty.DynamicVal
+	}
+
+	return resolved
+}
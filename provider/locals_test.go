@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// the locals and variable blocks of a single file, as parseLocals reads them
+func decodeLocalsBlocks(t *testing.T, src string) []LocalsBlock {
+	t.Helper()
+
+	file, diags := hclsyntax.ParseConfig([]byte(src), "main.tf", hcl.InitialPos)
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	var local LocalsBlock
+	if diags := gohcl.DecodeBody(file.Body, nil, &local); diags.HasErrors() {
+		t.Fatal(diags)
+	}
+	local.Filename, local.File = "main.tf", file
+	return []LocalsBlock{local}
+}
+
+func TestEvaluateLocals(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		values map[string]cty.Value // cty.DynamicVal for a local left unknown
+	}{
+		{
+			name: "dependencies before dependents",
+			src: `
+locals {
+  c = join("/", [local.b, local.a])
+  b = "${local.a}-b"
+  a = upper("a")
+}`,
+			values: map[string]cty.Value{
+				"a": cty.StringVal("A"),
+				"b": cty.StringVal("A-b"),
+				"c": cty.StringVal("A-b/A"),
+			},
+		},
+		{
+			name: "across locals blocks",
+			src: `
+locals {
+  account_id = local.accounts["logs"]
+}
+
+locals {
+  accounts = { logs = "222233334444" }
+}`,
+			values: map[string]cty.Value{
+				"account_id": cty.StringVal("222233334444"),
+				"accounts":   cty.ObjectVal(map[string]cty.Value{"logs": cty.StringVal("222233334444")}),
+			},
+		},
+		{
+			name: "cycle",
+			src: `
+locals {
+  a = local.b
+  b = local.a
+  c = "independent"
+}`,
+			values: map[string]cty.Value{
+				"a": cty.DynamicVal,
+				"b": cty.DynamicVal,
+				"c": cty.StringVal("independent"),
+			},
+		},
+		{
+			name: "undefined local",
+			src: `
+locals {
+  a = local.missing
+}`,
+			values: map[string]cty.Value{"a": cty.DynamicVal},
+		},
+		{
+			name: "function outside of the table",
+			src: `
+locals {
+  policy = file("policy.json")
+  name   = "${local.policy}-name"
+}`,
+			values: map[string]cty.Value{
+				"policy": cty.DynamicVal,
+				"name":   cty.DynamicVal,
+			},
+		},
+		{
+			name: "reference known only at plan",
+			src: `
+locals {
+  account_id = data.aws_caller_identity.current.account_id
+  region     = "eu-west-1"
+}`,
+			values: map[string]cty.Value{
+				"account_id": cty.DynamicVal,
+				"region":     cty.StringVal("eu-west-1"),
+			},
+		},
+		{
+			name: "input variables",
+			src: `
+locals {
+  name = "${var.env}-logs"
+}`,
+			values: map[string]cty.Value{"name": cty.StringVal("dev-logs")},
+		},
+		{
+			name: "first definition kept",
+			src: `
+locals {
+  env = "dev"
+}
+
+locals {
+  env = "prod"
+}`,
+			values: map[string]cty.Value{"env": cty.StringVal("dev")},
+		},
+	}
+
+	inputVariables := cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("dev")})
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locals := evaluateLocals(false, decodeLocalsBlocks(t, test.src), inputVariables, terraformFunctions())
+
+			if len(locals) != len(test.values) {
+				t.Errorf("got %d locals, want %d", len(locals), len(test.values))
+			}
+			for name, want := range test.values {
+				got, ok := locals[name]
+				unknown := want.RawEquals(cty.DynamicVal)
+				switch {
+				case !ok:
+					t.Errorf("local.%s missing", name)
+				case unknown && got.IsWhollyKnown():
+					t.Errorf("local.%s = %#v, want unknown", name, got)
+				case !unknown && !got.RawEquals(want):
+					t.Errorf("local.%s = %#v, want %#v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/b0bul/override/aws"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// attributes a mapping can't set through attributes, they're written from the mapping and the provider itself
+var reservedMappingAttributes = []string{"alias", "profile", "region", "for_each", "assume_role", "default_tags", "allowed_account_ids", "shared_credentials_files", "shared_config_files"}
+
+func isRegexLabel(label string) bool {
+	return len(label) > 2 && strings.HasPrefix(label, "/") && strings.HasSuffix(label, "/")
+}
+
+// a mapping matching more than one alias, a glob or a regular expression
+func (mapping OverrideConfigBody) IsPattern() bool {
+	return isRegexLabel(mapping.Alias) || strings.ContainsAny(mapping.Alias, "*?[")
+}
+
+// whether the mapping label matches an alias, default matches every alias
+func (mapping OverrideConfigBody) Matches(alias string) bool {
+	switch {
+	case mapping.Alias == "default" || mapping.Alias == alias:
+		return true
+	case isRegexLabel(mapping.Alias):
+		matched, err := regexp.MatchString(mapping.Alias[1:len(mapping.Alias)-1], alias)
+		return err == nil && matched
+	case mapping.IsPattern():
+		matched, err := path.Match(mapping.Alias, alias)
+		return err == nil && matched
+	}
+	return false
+}
+
+/*
+check mappings.hcl once decoded, profile_template has to read profiles unambiguously, patterns have to compile and
+attributes has to be an object not setting anything the mapping or provider already writes. file is the parsed mappings.hcl, blocks are decoded in order
+*/
+func validateMappings(file *hcl.File, config OverrideConfig) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	var blocks []*hclsyntax.Block
+	if body, ok := file.Body.(*hclsyntax.Body); ok {
+		for _, block := range body.Blocks {
+			if block.Type == "override" {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+
+	if config.ProfileTemplate != "" {
+		if err := checkProfileTemplate(config.ProfileTemplate); err != nil {
+			var subject *hcl.Range
+			if body, ok := file.Body.(*hclsyntax.Body); ok {
+				subject = body.Attributes["profile_template"].Expr.Range().Ptr()
+			}
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid profile_template",
+				Detail:   fmt.Sprintf("profile_template can't be used to read profiles, %s.", err),
+				Subject:  subject,
+			})
+		}
+	}
+
+	for i, mapping := range config.Override {
+		var subject *hcl.Range
+		if i < len(blocks) {
+			subject = blocks[i].LabelRanges[0].Ptr()
+		}
+
+		var err error
+		switch {
+		case isRegexLabel(mapping.Alias):
+			_, err = regexp.Compile(mapping.Alias[1 : len(mapping.Alias)-1])
+		case mapping.IsPattern():
+			_, err = path.Match(mapping.Alias, "")
+		}
+		if err != nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid alias pattern",
+				Detail:   fmt.Sprintf("The override label %q is not a valid pattern: %s.", mapping.Alias, err),
+				Subject:  subject,
+			})
+		}
+
+		if mapping.Profile != "" && (mapping.Account != "" || mapping.AccountId != "" || mapping.Role != "") {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Conflicting profile",
+				Detail:   fmt.Sprintf("The override %q sets a profile along with an account or role, set either the profile or the account and role it's made up of.", mapping.Alias),
+				Subject:  subject,
+			})
+		}
+
+		if mapping.Account != "" && mapping.AccountId != "" {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Conflicting account",
+				Detail:   fmt.Sprintf("The override %q sets both account and account_id, account takes a name or an id.", mapping.Alias),
+				Subject:  subject,
+			})
+		}
+
+		if mapping.AccountId != "" && !aws.IsAccountId(mapping.AccountId) {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid account_id",
+				Detail:   fmt.Sprintf("The override %q account_id %q is not a 12 digit aws account id.", mapping.Alias, mapping.AccountId),
+				Subject:  subject,
+			})
+		}
+
+		if mapping.Attributes == cty.NilVal || mapping.Attributes.IsNull() {
+			continue
+		}
+
+		if i < len(blocks) {
+			if attribute, ok := blocks[i].Body.Attributes["attributes"]; ok {
+				subject = attribute.Expr.Range().Ptr()
+			}
+		}
+
+		if !mapping.Attributes.IsWhollyKnown() || !(mapping.Attributes.Type().IsObjectType() || mapping.Attributes.Type().IsMapType()) {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid attributes",
+				Detail:   "attributes must be an object of provider attribute names and values known before plan.",
+				Subject:  subject,
+			})
+			continue
+		}
+
+		for name := range mapping.Attributes.AsValueMap() {
+			for _, reserved := range reservedMappingAttributes {
+				if name == reserved {
+					diags = append(diags, &hcl.Diagnostic{
+						Severity: hcl.DiagError,
+						Summary:  "Reserved provider attribute",
+						Detail:   fmt.Sprintf("%s is written from the mapping and provider, it can't be set through attributes.", name),
+						Subject:  subject,
+					})
+				}
+			}
+		}
+	}
+
+	return diags
+}
+
+// a decoded mapping file with every entry recording the file it's declared in
+func sourcedMappings(mappingFile string, config OverrideConfig) OverrideConfig {
+	if config.ProfileTemplate != "" {
+		config.ProfileTemplateSource = mappingFile
+	}
+	for i := range config.Override {
+		config.Override[i].Source = mappingFile
+	}
+	if config.Backend != nil {
+		config.Backend.Source = mappingFile
+	}
+	for i := range config.RemoteStates {
+		config.RemoteStates[i].Source = mappingFile
+	}
+	return config
+}
+
+/*
+merge mapping files layered lowest precedence first. override blocks of every file are kept, those of a higher file
+ahead of a lower one so a mapping only loses to one of a lower file on priority or a closer match, each setting
+is then taken from the highest mapping setting it. profile_template and backend are taken from the highest file
+setting them and a remote_state from the highest file declaring one of that name
+*/
+func mergeMappings(layers []OverrideConfig) OverrideConfig {
+	var merged OverrideConfig
+
+	remoteStates := make(map[string]bool)
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer := layers[i]
+
+		if merged.ProfileTemplate == "" {
+			merged.ProfileTemplate, merged.ProfileTemplateSource = layer.ProfileTemplate, layer.ProfileTemplateSource
+		}
+
+		merged.Override = append(merged.Override, layer.Override...)
+
+		if merged.Backend == nil {
+			merged.Backend = layer.Backend
+		}
+
+		for _, remoteState := range layer.RemoteStates {
+			if !remoteStates[remoteState.Name] {
+				remoteStates[remoteState.Name] = true
+				merged.RemoteStates = append(merged.RemoteStates, remoteState)
+			}
+		}
+	}
+
+	return merged
+}
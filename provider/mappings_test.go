@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		label   string
+		alias   string
+		matches bool
+	}{
+		{"default", "logs", true},
+		{"default", "unaliased", true},
+		{"logs", "logs", true},
+		{"logs", "logs-archive", false},
+		{"transit-*", "transit-eu", true},
+		{"transit-*", "transit", false},
+		{"transit-?", "transit-a", true},
+		{"[ab]-dns", "b-dns", true},
+		{"/^transit-(eu|us)$/", "transit-us", true},
+		{"/^transit-(eu|us)$/", "transit-ap", false},
+		{"/^dns/", "dns-primary", true},
+		// an invalid pattern matches nothing, validateMappings reports it
+		{"/^transit-(eu$/", "transit-eu", false},
+		{"transit-[", "transit-a", false},
+	}
+
+	for _, test := range tests {
+		mapping := OverrideConfigBody{Alias: test.label}
+		if matches := mapping.Matches(test.alias); matches != test.matches {
+			t.Errorf("override %q Matches(%q) = %v, want %v", test.label, test.alias, matches, test.matches)
+		}
+	}
+}
+
+func decodeMappings(t *testing.T, src string) (*hcl.File, OverrideConfig) {
+	t.Helper()
+
+	file, diags := hclsyntax.ParseConfig([]byte(src), "mappings.hcl", hcl.InitialPos)
+	if diags.HasErrors() {
+		t.Fatal(diags)
+	}
+
+	var config OverrideConfig
+	if diags := gohcl.DecodeBody(file.Body, nil, &config); diags.HasErrors() {
+		t.Fatal(diags)
+	}
+	return file, config
+}
+
+func TestValidateMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		summary string // of the only diagnostic, empty when the mappings are valid
+	}{
+		{
+			name: "valid",
+			src: `
+profile_template = "{account}-{role}"
+override "default" {
+  role = "ReadOnly"
+}
+override "/^transit-.*$/" {
+  account = "org-network-prod"
+}
+override "dns-*" {
+  profile = "org-dns-prod-ReadOnly"
+}`,
+		},
+		{
+			name:    "invalid regex",
+			src:     `override "/^transit-(eu$/" { profile = "a" }`,
+			summary: "Invalid alias pattern",
+		},
+		{
+			name:    "invalid glob",
+			src:     `override "transit-[" { profile = "a" }`,
+			summary: "Invalid alias pattern",
+		},
+		{
+			name:    "invalid profile_template",
+			src:     `profile_template = "{account}{role}"`,
+			summary: "Invalid profile_template",
+		},
+		{
+			name: "profile with role",
+			src: `
+override "logs" {
+  profile = "a"
+  role    = "ReadOnly"
+}`,
+			summary: "Conflicting profile",
+		},
+		{
+			name: "account and account_id",
+			src: `
+override "logs" {
+  account    = "org-logs-prod"
+  account_id = "222233334444"
+}`,
+			summary: "Conflicting account",
+		},
+		{
+			name:    "short account_id",
+			src:     `override "logs" { account_id = "2222" }`,
+			summary: "Invalid account_id",
+		},
+		{
+			name:    "reserved attribute",
+			src:     `override "logs" { attributes = { region = "eu-west-1" } }`,
+			summary: "Reserved provider attribute",
+		},
+		{
+			name:    "attributes not an object",
+			src:     `override "logs" { attributes = "skip_metadata_api_check" }`,
+			summary: "Invalid attributes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, config := decodeMappings(t, test.src)
+			diags := validateMappings(file, config)
+
+			if test.summary == "" {
+				if len(diags) != 0 {
+					t.Fatalf("want no diagnostics, got %v", diags)
+				}
+				return
+			}
+			if len(diags) != 1 || diags[0].Summary != test.summary {
+				t.Fatalf("want a %q diagnostic, got %v", test.summary, diags)
+			}
+			if diags[0].Subject == nil {
+				t.Errorf("%q diagnostic has no subject", test.summary)
+			}
+		})
+	}
+}
+
+func TestMergeMappings(t *testing.T) {
+	user := sourcedMappings("user.hcl", OverrideConfig{
+		ProfileTemplate: "{account}-{role}",
+		Override: []OverrideConfigBody{
+			{Alias: "default", Role: "ReadOnly"},
+			{Alias: "logs", Profile: "user-logs"},
+		},
+		Backend:      &BackendMapping{Profile: "user-backend"},
+		RemoteStates: []RemoteStateMapping{{Name: "network", Profile: "user-network"}, {Name: "dns", Profile: "user-dns"}},
+	})
+	team := sourcedMappings("team.hcl", OverrideConfig{
+		Override: []OverrideConfigBody{{Alias: "logs", Profile: "team-logs"}},
+	})
+	repo := sourcedMappings("mappings.hcl", OverrideConfig{
+		ProfileTemplate: "{org}-{account}-{env}-{role}",
+		Override:        []OverrideConfigBody{{Alias: "logs", Profile: "repo-logs"}},
+		RemoteStates:    []RemoteStateMapping{{Name: "network", Profile: "repo-network"}},
+	})
+
+	merged := mergeMappings([]OverrideConfig{user, team, repo})
+
+	if merged.ProfileTemplate != repo.ProfileTemplate || merged.ProfileTemplateSource != "mappings.hcl" {
+		t.Errorf("profile_template = %q from %q, want the repo's", merged.ProfileTemplate, merged.ProfileTemplateSource)
+	}
+
+	// the repo mapping comes first so it wins a tie with the user mapping for the same alias
+	var sources []string
+	for _, mapping := range merged.Override {
+		sources = append(sources, mapping.Source+":"+mapping.Alias)
+	}
+	if got, want := strings.Join(sources, " "), "mappings.hcl:logs team.hcl:logs user.hcl:default user.hcl:logs"; got != want {
+		t.Errorf("override order = %s, want %s", got, want)
+	}
+
+	// only the user file declares a backend
+	if merged.Backend == nil || merged.Backend.Profile != "user-backend" {
+		t.Errorf("backend = %v, want the user's", merged.Backend)
+	}
+
+	remoteStates := make(map[string]string)
+	for _, remoteState := range merged.RemoteStates {
+		if _, ok := remoteStates[remoteState.Name]; ok {
+			t.Errorf("remote_state %q merged more than once", remoteState.Name)
+		}
+		remoteStates[remoteState.Name] = remoteState.Profile
+	}
+	if remoteStates["network"] != "repo-network" || remoteStates["dns"] != "user-dns" {
+		t.Errorf("remote_state profiles = %v, want network from the repo and dns from the user", remoteStates)
+	}
+}
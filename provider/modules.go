@@ -0,0 +1,275 @@
+package provider
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const modulesManifest string = ".terraform/modules/modules.json"
+
+// marks override files written into child modules and backend overrides so restore never removes a file it didn't write
+const ManagedFileComment string = "overrides managed"
+const ManagedFileHeader string = "# " + ManagedFileComment
+
+// a provider configuration declared by, or expected from the caller of, a child module
+type ModuleProvider struct {
+	Module               string
+	Type                 string
+	Alias                string
+	AssumesRole          bool
+	RoleArn              string                // the assume_role role_arn as written, module values come from the caller
+	AssumeRoleAttributes []AssumeRoleAttribute // every other assume_role attribute as written
+	ConfigurationAlias   bool                  // passed in by the caller through configuration_aliases, overridden with the root providers
+}
+
+type modulesManifestBody struct {
+	Modules []struct {
+		Key    string `json:"Key"`
+		Source string `json:"Source"`
+		Dir    string `json:"Dir"`
+	} `json:"Modules"`
+}
+
+func isLocalSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+// parse every .tf, .tofu and json file of a module without decoding, child module values come from their caller so can't be evaluated
+func parseModuleFiles(verbose bool, parser *hclparse.Parser, dir string) []*hcl.File {
+	var files []*hcl.File
+	diagnostics := newFileDiagnostics()
+
+	for _, f := range terraformFiles(verbose, dir) {
+		file, diags := parseTerraformFile(parser, f)
+		diagnostics.add(f, file, diags)
+		if diags.HasErrors() {
+			if verbose {
+				log.Println("error parsing", f)
+			}
+			continue
+		}
+		files = append(files, file)
+	}
+
+	diagnostics.report()
+
+	return files
+}
+
+func syntaxBlocks(file *hcl.File, blockType string) []*hclsyntax.Block {
+	var blocks []*hclsyntax.Block
+	if body, ok := file.Body.(*hclsyntax.Body); ok {
+		for _, block := range body.Blocks {
+			if block.Type == blockType {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks
+}
+
+// blocks of a module file in either syntax, json files have no syntax tree so they're read through a schema
+func moduleBlocks(file *hcl.File, blockType string, labels ...string) hcl.Blocks {
+	content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: blockType, LabelNames: labels}},
+	})
+	return content.Blocks
+}
+
+// literal string attribute of a block, empty when missing or not a literal
+func literalAttribute(block *hcl.Block, name string) string {
+	content, _, _ := block.Body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: name}},
+	})
+	attribute, ok := content.Attributes[name]
+	if !ok {
+		return ""
+	}
+
+	value, diags := attribute.Expr.Value(nil)
+	if diags.HasErrors() || !value.IsKnown() || value.IsNull() || value.Type() != cty.String {
+		return ""
+	}
+	return value.AsString()
+}
+
+/*
+Local child modules of the root module, found by following module blocks with a ./ or ../ source from the root
+down, along with every module with a local source recorded in .terraform/modules/modules.json after terraform init
+*/
+func LocalModules(verbose bool) []string {
+	parser := hclparse.NewParser()
+	found := make(map[string]bool)
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		for _, file := range parseModuleFiles(verbose, parser, dir) {
+			for _, block := range moduleBlocks(file, "module", "name") {
+				source := literalAttribute(block, "source")
+				if !isLocalSource(source) {
+					continue
+				}
+
+				moduleDir := filepath.Clean(filepath.Join(dir, source))
+				if found[moduleDir] {
+					continue
+				}
+
+				if _, err := os.Stat(moduleDir); err != nil {
+					if verbose {
+						log.Printf("module %s source %s not found, skipping", block.Labels[0], source)
+					}
+					continue
+				}
+
+				if verbose {
+					log.Printf("found local module %s at %s", block.Labels[0], moduleDir)
+				}
+				found[moduleDir] = true
+				walk(moduleDir)
+			}
+		}
+	}
+	walk(".")
+
+	if manifest, err := os.ReadFile(modulesManifest); err == nil {
+		var installed modulesManifestBody
+		if err := json.Unmarshal(manifest, &installed); err != nil {
+			if verbose {
+				log.Println("error reading", modulesManifest)
+			}
+			check(err)
+		}
+
+		for _, module := range installed.Modules {
+			moduleDir := filepath.Clean(module.Dir)
+			if module.Key == "" || moduleDir == "." || found[moduleDir] {
+				continue
+			}
+			// registry and git modules are a copy in .terraform/modules that terraform init replaces, they're left alone
+			if !isLocalSource(module.Source) {
+				if verbose {
+					log.Printf("installed module %s source %s is not local, skipping", module.Key, module.Source)
+				}
+				continue
+			}
+			if verbose {
+				log.Printf("found installed module %s at %s", module.Key, moduleDir)
+			}
+			found[moduleDir] = true
+		}
+	}
+
+	modules := make([]string, 0, len(found))
+	for dir := range found {
+		modules = append(modules, dir)
+	}
+	sort.Strings(modules)
+
+	return modules
+}
+
+// configuration_aliases = [aws.secondary] in required_providers
+func configurationAliases(block *hcl.Block) []string {
+	var aliases []string
+
+	content, _, _ := block.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "required_providers"}},
+	})
+	for _, requiredProviders := range content.Blocks {
+		attributes, _ := requiredProviders.Body.JustAttributes()
+		for _, attribute := range attributes {
+			pairs, diags := hcl.ExprMap(attribute.Expr)
+			if diags.HasErrors() {
+				continue
+			}
+
+			for _, pair := range pairs {
+				if hcl.ExprAsKeyword(pair.Key) != "configuration_aliases" {
+					continue
+				}
+
+				references, diags := hcl.ExprList(pair.Value)
+				if diags.HasErrors() {
+					continue
+				}
+
+				for _, reference := range references {
+					traversal, diags := hcl.AbsTraversalForExpr(reference)
+					if diags.HasErrors() || len(traversal) != 2 {
+						continue
+					}
+					if alias, ok := traversal[1].(hcl.TraverseAttr); ok {
+						aliases = append(aliases, traversal.RootName()+"."+alias.Name)
+					}
+				}
+			}
+		}
+	}
+
+	return aliases
+}
+
+// every provider configuration a child module declares or expects to be passed
+func ModuleProviders(verbose bool, dir string) []ModuleProvider {
+	var providers []ModuleProvider
+
+	for _, file := range parseModuleFiles(verbose, hclparse.NewParser(), dir) {
+		_, native := file.Body.(*hclsyntax.Body)
+
+		for _, block := range moduleBlocks(file, "provider", "type") {
+			provider := ModuleProvider{
+				Module: dir,
+				Type:   block.Labels[0],
+				Alias:  literalAttribute(block, "alias"),
+			}
+
+			content, _, _ := block.Body.PartialContent(&hcl.BodySchema{
+				Blocks: []hcl.BlockHeaderSchema{{Type: "assume_role"}},
+			})
+			for _, nested := range content.Blocks {
+				provider.AssumesRole = true
+				provider.AssumeRoleAttributes = assumeRoleAttributes(nested.Body, file.Bytes, !native, nil)
+
+				roleArn, _, _ := nested.Body.PartialContent(&hcl.BodySchema{
+					Attributes: []hcl.AttributeSchema{{Name: "role_arn"}},
+				})
+				if attribute, ok := roleArn.Attributes["role_arn"]; ok {
+					provider.RoleArn, _ = quotedSource(attribute.Expr, file.Bytes)
+				}
+			}
+
+			providers = append(providers, provider)
+		}
+
+		for _, block := range moduleBlocks(file, "terraform") {
+			for _, reference := range configurationAliases(block) {
+				providerType, alias, _ := strings.Cut(reference, ".")
+				providers = append(providers, ModuleProvider{
+					Module:             dir,
+					Type:               providerType,
+					Alias:              alias,
+					ConfigurationAlias: true,
+				})
+			}
+		}
+	}
+
+	return providers
+}
+
+// remove override files written into child modules, files without the managed header are left alone
+func RemoveModuleOverrideFiles(verboseLogging bool, overrideFile string) {
+	for _, dir := range LocalModules(verboseLogging) {
+		RemoveManagedOverrideFile(verboseLogging, filepath.Join(dir, strings.TrimSuffix(overrideFile, jsonFileExtension)))
+	}
+}
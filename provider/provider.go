@@ -1,158 +1,189 @@
 package provider
 
 import (
-	"fmt"
-	"io"
+	"bytes"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclsimple"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	hcljson "github.com/hashicorp/hcl/v2/json"
 	"github.com/zclconf/go-cty/cty"
 )
 
 const providerBackupFileExtension string = ".overrides"
-const hclFileExtension string = ".hcl"
 const tfFileExtension string = ".tf"
+const tofuFileExtension string = ".tofu"
+
+// every extension a module file can have, opentofu reads .tofu files alongside .tf files
+var terraformFileExtensions = []string{tfFileExtension, tfJsonFileExtension, tofuFileExtension, tofuJsonFileExtension}
 
 func check(err error) {
 	if err != nil {
-		log.Fatalln(err)
+		Fail(err)
 	}
 }
 
 /*
 The structure of a providers.tf file can vary wildly depend on what fields of the provider block are present
 There for in the overrides.tf file a simpler provider block replaces the more complex original one discarding
-certain fields, like tags and allowed_account_ids etc
+certain fields, like assume_role and the profile etc
 */
 type ProviderConfig struct {
-	Terraform *TerrafromConfigBody    `hcl:"terraform,block"` // discarded when writing overrides.tf
+	Terraform []TerrafromConfigBody   `hcl:"terraform,block"` // copied verbatim when writing overrides.tf
 	Providers []AwsProviderConfigBody `hcl:"provider,block"`
+	Options   hcl.Body                `hcl:",remain"` // discard
 }
 
+// tags are kept as the evaluated value, a map of strings doesn't decode from merge() or values that aren't strings
 type Tag struct {
-	//Tags interface{} `hcl:"tags,optional"` // discarded when writing overrides.tf
-	Tags map[string]string `hcl:"tags,optional"` // discarded when writing overrides.tf
-
+	Tags cty.Value `hcl:"tags,optional"`
 }
 
+// role_arn is kept unevaluated, ParseProviderFile fills in Template so a read only role can replace the role name
 type AssumedRole struct {
-	Tags interface{} `hcl:"role_arn,optional"` // discarded when writing overrides.tf
+	RoleArn    *hcl.Attribute        `hcl:"role_arn,optional"`
+	Options    hcl.Body              `hcl:",remain"` // session_name, external_id etc.
+	Template   string                // role_arn as the contents of a string template
+	Attributes []AssumeRoleAttribute // Options in native syntax, written again by an override of the block
+}
+
+type AssumeRoleAttribute struct {
+	Name string
+	Expr string
 }
 
 type TerrafromConfigBody struct {
-	RequiredProvider hcl.Body `hcl:",remain"` // discarded when writing overrides.tf - defer decoding to the required_providers block
+	RequiredProvider hcl.Body `hcl:",remain"` // never decoded, the block is copied from the syntax tree
 }
 
 // Properties here are optional, due to "template" and "archive" providers having more often than not, no arguments {}
 type AwsProviderConfigBody struct {
-	Type              string       `hcl:"type,label"`
-	Region            string       `hcl:"region,optional"`
-	Alias             string       `hcl:"alias,optional"`
-	AllowedAccountIds []string     `hcl:"allowed_account_ids,optional"` // discarded when writing overrides.tf
-	DefaultTags       *Tag         `hcl:"default_tags,block"`           // *Block are set to nil pointer when empty this is how they're ignored
-	AssumeRole        *AssumedRole `hcl:"assume_role,block"`            // *Block are set to nil pointer when empty this is how they're ignored
+	Type                       string                  `hcl:"type,label"`
+	RegionAttribute            *hcl.Attribute          `hcl:"region,optional"` // kept unevaluated, ParseProviderFile fills in Region or RegionSource
+	Region                     string                  // evaluated region, empty when unset or only known at plan
+	RegionSource               string                  // the region expression as written when it's only known at plan
+	Alias                      string                  `hcl:"alias,optional"`
+	AllowedAccountIdsAttribute *hcl.Attribute          `hcl:"allowed_account_ids,optional"` // kept unevaluated, ParseProviderFile fills in AllowedAccountIds or AllowedAccountIdsSource
+	AllowedAccountIds          []string                // evaluated ids, kept as a guard against planning with the wrong account, nil when unset or only known at plan
+	AllowedAccountIdsSource    string                  // the allowed_account_ids expression as written when it's only known at plan
+	DefaultTags                *Tag                    `hcl:"default_tags,block"` // *Block are set to nil pointer when empty this is how they're ignored
+	AssumeRole                 *AssumedRole            `hcl:"assume_role,block"`  // *Block are set to nil pointer when empty this is how they're ignored
+	ForEach                    *hcl.Attribute          `hcl:"for_each,optional"`  // opentofu provider iteration, ParseProviderFile expands it into Instances
+	Options                    hcl.Body                `hcl:",remain"`            // endpoints, ignore_tags, skip_* etc. kept only by a lossless rewrite
+	ForEachSource              string                  // the for_each expression as written
+	Instances                  []AwsProviderConfigBody // one per for_each key, empty when for_each is only known at plan
+	InstanceKey                string                  // the for_each key of an instance
 }
 
 type OverrideConfig struct {
-	Override []OverrideConfigBody `hcl:"override,block"`
+	ProfileTemplate       string               `hcl:"profile_template,optional"` // how profiles are made up, --alias and --set replace its placeholders
+	Override              []OverrideConfigBody `hcl:"override,block"`
+	Backend               *BackendMapping      `hcl:"backend,block"` // *Block are set to nil pointer when empty, no backend override is written
+	RemoteStates          []RemoteStateMapping `hcl:"remote_state,block"`
+	ProfileTemplateSource string               // the mapping file profile_template is set in
 }
 
-type OverrideConfigBody struct {
-	Alias   string `hcl:"alias,label"`
+// the profile a terraform_remote_state data source reads state with, by data source name or default for every other
+type RemoteStateMapping struct {
+	Name    string `hcl:"name,label"`
 	Profile string `hcl:"profile,attr"`
+	Source  string // the mapping file it's declared in
 }
 
-// add new locals here
-type LocalValues struct {
-	RepositoryName string            `hcl:"repository_name,optional"`
-	DefaultTags    map[string]string `hcl:"default_tags,optional"`
-	Options        hcl.Body          `hcl:",remain"` // discard
+// the profile the s3 backend reads state and takes the dynamodb lock with
+type BackendMapping struct {
+	Profile string `hcl:"profile,attr"`
+	Source  string // the mapping file it's declared in
 }
 
-type LocalsBlock struct {
-	Locals  []LocalValues `hcl:"locals,block"`
-	Options hcl.Body      `hcl:",remain"` // discard
+// the label is an alias, a glob such as transit-* or a regular expression written as /^transit-.*$/
+type OverrideConfigBody struct {
+	Alias      string    `hcl:"alias,label"`
+	Profile    string    `hcl:"profile,optional"`
+	Account    string    `hcl:"account,optional"`    // an account name or id, with role the profile refresh wrote for it is looked up in the account inventory
+	AccountId  string    `hcl:"account_id,optional"` // the account by id, unlike its name it survives an account being renamed
+	Role       string    `hcl:"role,optional"`       // the role of the profile in the account the provider targets
+	Region     string    `hcl:"region,optional"`     // replaces the region of the matched provider, the default mapping only fills in unknown regions
+	Priority   int       `hcl:"priority,optional"`   // mappings matching the same provider are applied highest priority first
+	Attributes cty.Value `hcl:"attributes,optional"` // extra attributes set on the generated provider, such as skip_metadata_api_check
+	Source     string    // the mapping file it's declared in
 }
 
-// providers file can be provider.tf or providers.tf
-func setProvidersFile(verbose bool) (providers string, backup string) {
-	supportProviderFiles := []string{"providers.tf", "provider.tf"}
-	for _, providerFile := range supportProviderFiles {
-
-		potentialProviderBackup := providerFile + providerBackupFileExtension
-
-		_, err := os.Stat(providerFile)
-
-		if err != nil {
-			if verbose {
-				log.Println("no provider file named:", providerFile)
-			}
-		} else {
-			providers = providerFile
-		}
-
-		_, err = os.Stat(potentialProviderBackup)
-
-		if err != nil {
-			if verbose {
-				log.Println("no backup file named:", potentialProviderBackup)
-			} // if it just doesn't exist it needs to be created later
-		} else {
-			backup = potentialProviderBackup
-		}
-
-		if providers == "" && backup == "" {
-			log.Fatalln("no provider or backup file detect")
-		}
+// locals are evaluated from their expressions, see evaluateLocals
+type LocalValues struct {
+	Config hcl.Body `hcl:",remain"`
+}
 
-		if backup == "" {
-			backup = providers + providerBackupFileExtension
-		}
+type LocalsBlock struct {
+	Locals    []LocalValues   `hcl:"locals,block"`
+	Variables []VariableBlock `hcl:"variable,block"`
+	Options   hcl.Body        `hcl:",remain"` // discard
+	Filename  string          // the file the blocks are declared in, for diagnostics
+	File      *hcl.File
+}
 
-		if providers == "" {
-			providers = strings.TrimSuffix(backup, filepath.Ext(backup))
+func isTerraformFile(name string) bool {
+	for _, extension := range terraformFileExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
 		}
 	}
-	return providers, backup
+	return false
 }
 
-func cleanTemp(verbose bool, tmp string) {
-	filesToRemove, err := os.ReadDir(tmp)
+// opentofu ignores foo.tf when foo.tofu exists, and foo.tf.json when foo.tofu.json does
+func shadowedByTofuFile(dir string, name string) bool {
+	var tofu string
+	switch {
+	case strings.HasSuffix(name, tfFileExtension):
+		tofu = strings.TrimSuffix(name, tfFileExtension) + tofuFileExtension
+	case strings.HasSuffix(name, tfJsonFileExtension):
+		tofu = strings.TrimSuffix(name, tfJsonFileExtension) + tofuJsonFileExtension
+	default:
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(dir, tofu))
+	return err == nil
+}
 
+// every .tf, .tofu and json file of a module, anything named *override* is either a terraform override file or written by this app
+func terraformFiles(verbose bool, dir string) []string {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if verbose {
-			log.Println("error reading temp dir", tmp)
-			log.Println("cleaning temp", tmp)
+			log.Println("error reading module directory", dir)
 		}
 		check(err)
 	}
 
-	if verbose {
-		log.Println("successfully read temp dir", tmp)
-	}
-
-	for _, path := range filesToRemove {
-		if !path.IsDir() {
-			if strings.Contains(path.Name(), hclFileExtension) {
-				filename := filepath.Join(tmp, path.Name())
-				err = os.Remove(filename)
-				if err != nil {
-					if verbose {
-						log.Println("error cleaning temp dir", filename)
-					}
-					check(err)
+	var files []string
+	for _, f := range entries {
+		if !f.IsDir() {
+			if !isTerraformFile(f.Name()) || strings.Contains(f.Name(), "override") {
+				continue
+			}
+			if shadowedByTofuFile(dir, f.Name()) {
+				if verbose {
+					log.Printf("skipping %s, opentofu reads the .tofu file of the same name instead", f.Name())
 				}
+				continue
 			}
+			files = append(files, filepath.Join(dir, f.Name()))
 		}
 	}
+	return files
 }
 
-func parseLocals(verbose bool, tmp string) []LocalsBlock {
-	cleanTemp(verbose, tmp)
+// parse every .tf, .tofu and json file of the root module in memory, only locals and variable blocks are decoded
+func parseLocals(verbose bool) []LocalsBlock {
 
 	if verbose {
 		log.Println("parsing locals")
@@ -160,277 +191,541 @@ func parseLocals(verbose bool, tmp string) []LocalsBlock {
 
 	allLocalsBlocksFound := []LocalsBlock{}
 
-	terraformFiles, err := os.ReadDir(".")
-	if err != nil {
-		if verbose {
-			log.Println("error reading the current directory")
+	parser := hclparse.NewParser()
+	diagnostics := newFileDiagnostics()
+
+	// provider blocks have been moved out of these files, everything else is still in place
+	for _, f := range terraformFiles(verbose, ".") {
+		var local LocalsBlock
+
+		file, diags := parseTerraformFile(parser, f)
+		diagnostics.add(f, file, diags)
+		if diags.HasErrors() {
+			if verbose {
+				log.Println("error parsing", f)
+			}
+			continue
 		}
-		check(err)
-	}
 
-	for _, f := range terraformFiles {
-		if !f.IsDir() {
-			if strings.HasSuffix(f.Name(), tfFileExtension) && !strings.Contains(f.Name(), "override") {
-				hcl_fn := f.Name() + hclFileExtension
-				createHclCopy(verbose, hcl_fn, f.Name(), tmp)
+		diags = gohcl.DecodeBody(file.Body, nil, &local)
+		diagnostics.add(f, file, diags)
+		if diags.HasErrors() {
+			if verbose {
+				log.Println("error performing hcl decode on", f)
 			}
+			continue
 		}
+
+		local.Filename, local.File = f, file
+		allLocalsBlocksFound = append(allLocalsBlocksFound, local)
 	}
 
-	// files removed, so tmp is empty
-	hclFiles, err := os.ReadDir(tmp)
-	if err != nil {
-		if verbose {
-			log.Println("error reading tmp", tmp)
-		}
-		check(err)
+	// every file is parsed before reporting so all errors are shown at once
+	diagnostics.report()
+
+	return allLocalsBlocksFound
+}
+
+/*
+hcl is a strucutred configuration language not a data structure serialization language like JSON,YAML or TOML
+hcl is always decoded using an application-defined schema not via tokenization.
+here every local and input variable found in the module is evaluated, including locals referencing other locals,
+string templates and built-in functions such as merge, lookup and format
+this is required to read the providers.tf file where locals are declared in main.tf or some other file
+*/
+func ModuleEvalContext(verboseLogging bool, varFiles []string) *hcl.EvalContext {
+
+	blocks := parseLocals(verboseLogging)
+
+	variables := cty.ObjectVal(evaluateVariables(verboseLogging, blocks, varFiles))
+
+	return &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var":   variables,
+			"local": cty.ObjectVal(evaluateLocals(verboseLogging, blocks, variables, terraformFunctions())),
+		},
+		Functions: terraformFunctions(),
 	}
+}
 
-	for _, f := range hclFiles {
-		var local LocalsBlock
-		if strings.HasSuffix(f.Name(), hclFileExtension) {
-			hcl_fn := filepath.Join(tmp, f.Name())
-			hclFile, err := os.Open(hcl_fn)
+// read the provider blocks of every backed up file and interpolate locals and variables
+func ParseProviderFile(verboseLogging bool, backedupProviderFiles []string, ctx *hcl.EvalContext) ProviderConfig {
 
-			if err != nil {
-				if verbose {
-					log.Println("error opening", hcl_fn)
-				}
-				check(err)
-			}
+	var config ProviderConfig
 
-			defer hclFile.Close()
+	diagnostics := newFileDiagnostics()
 
-			err = hclsimple.DecodeFile(hclFile.Name(), nil, &local)
+	for _, backup := range backedupProviderFiles {
+		if verboseLogging {
+			log.Println("parsing provider file", backup)
+		}
 
-			if err != nil {
-				if verbose {
-					log.Println("error performing hcl decode on", hclFile.Name())
-				}
-				check(err)
+		src, err := os.ReadFile(backup)
+		if err != nil {
+			if verboseLogging {
+				log.Println("error reading provider file", backup)
 			}
+			check(err)
+		}
+
+		// diagnostics refer to the original file name rather than the backup
+		original := strings.TrimSuffix(backup, providerBackupFileExtension)
 
-			allLocalsBlocksFound = append(allLocalsBlocksFound, local)
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		if isJSONFile(backup) {
+			file, diags = hcljson.Parse(src, original)
+		} else {
+			file, diags = hclsyntax.ParseConfig(src, original, hcl.InitialPos)
+		}
+		diagnostics.add(original, file, diags)
+		if diags.HasErrors() {
+			continue
 		}
+
+		fileConfig := decodeProviderConfig(original, file, file.Body, isJSONFile(backup), ctx, diagnostics)
+
+		config.Terraform = append(config.Terraform, fileConfig.Terraform...)
+		config.Providers = append(config.Providers, fileConfig.Providers...)
 	}
 
-	return allLocalsBlocksFound
+	diagnostics.report()
+
+	return config
 }
 
-// copy .tf files as .hcl file to tmp
-func createHclCopy(verbose bool, hclFile string, tfFile string, tmp string) string {
-	if verbose {
-		log.Println("create hcl copy", tfFile)
-	}
+/*
+decode the terraform and provider blocks of a body and evaluate what can be known of each provider before plan.
+source is the file the body was parsed from, expression ranges and diagnostics refer to it
+*/
+func decodeProviderConfig(filename string, source *hcl.File, body hcl.Body, json bool, ctx *hcl.EvalContext, diagnostics *fileDiagnostics) ProviderConfig {
+	var config ProviderConfig
 
-	tfConfig, err := os.Open(tfFile)
+	ctx = providerEvalContext(body, ctx)
 
-	if err != nil {
-		if verbose {
-			log.Println("error reading terraform file:", tfFile)
+	diags := gohcl.DecodeBody(body, ctx, &config)
+	diagnostics.add(filename, source, diags)
+	if diags.HasErrors() {
+		return ProviderConfig{}
+	}
+
+	for i, provider := range config.Providers {
+		if provider.AssumeRole != nil {
+			provider.AssumeRole.Attributes = assumeRoleAttributes(provider.AssumeRole.Options, source.Bytes, json, ctx)
 		}
-		check(err)
+		if provider.AssumeRole != nil && provider.AssumeRole.RoleArn != nil {
+			provider.AssumeRole.Template = roleArnTemplate(provider.AssumeRole.RoleArn.Expr, source.Bytes, ctx)
+		}
+		setProviderRegion(&config.Providers[i], source.Bytes, json, ctx)
+		diagnostics.add(filename, source, setProviderAllowedAccountIds(&config.Providers[i], source.Bytes, json, ctx))
+		diagnostics.add(filename, source, expandProviderInstances(&config.Providers[i], source.Bytes, json, ctx))
 	}
 
-	fullPath := filepath.Join(tmp, hclFile)
+	return config
+}
 
-	hclConfig, err := os.OpenFile(fullPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY|os.O_TRUNC, 0644)
+// references outside of var and local, such as a data source in default_tags, are unknown until plan time
+func providerEvalContext(hclBody hcl.Body, ctx *hcl.EvalContext) *hcl.EvalContext {
+	body, ok := hclBody.(*hclsyntax.Body)
+	if !ok || ctx == nil {
+		return ctx
+	}
 
-	if err != nil {
-		if verbose {
-			log.Println("error reading hcl file:", fullPath)
+	unknown := make(map[string]cty.Value)
+	hclsyntax.VisitAll(body, func(node hclsyntax.Node) hcl.Diagnostics {
+		if expr, ok := node.(*hclsyntax.ScopeTraversalExpr); ok {
+			if _, ok := ctx.Variables[expr.Traversal.RootName()]; !ok {
+				unknown[expr.Traversal.RootName()] = cty.DynamicVal
+			}
 		}
-		check(err)
+		return nil
+	})
+
+	if len(unknown) == 0 {
+		return ctx
 	}
 
-	defer hclConfig.Close()
-	defer tfConfig.Close()
+	child := ctx.NewChild()
+	child.Variables = unknown
+	return child
+}
+
+/*
+read mapping files layered lowest precedence first and merge them, see mergeMappings. Every file is decoded with
+the context of the root module and the errors of all of them are reported together
+*/
+func ParseOverrideConfig(mappingFiles []string, ctx *hcl.EvalContext) OverrideConfig {
 
-	_, err = io.Copy(hclConfig, tfConfig)
+	diagnostics := newFileDiagnostics()
 
-	if err != nil {
-		if verbose {
-			log.Printf("error copying %s to %s", tfConfig.Name(), hclConfig.Name())
+	var layers []OverrideConfig
+
+	parser := hclparse.NewParser()
+	for _, mappingFile := range mappingFiles {
+		var config OverrideConfig
+
+		file, diags := parser.ParseHCLFile(mappingFile)
+		diagnostics.add(mappingFile, file, diags)
+		if diags.HasErrors() {
+			continue
 		}
-		check(err)
+
+		diags = gohcl.DecodeBody(file.Body, ctx, &config)
+		diagnostics.add(mappingFile, file, diags)
+		if diags.HasErrors() {
+			continue
+		}
+		diagnostics.add(mappingFile, file, validateMappings(file, config))
+
+		layers = append(layers, sourcedMappings(mappingFile, config))
 	}
 
-	return hclConfig.Name()
+	diagnostics.report()
+
+	return mergeMappings(layers)
 }
 
-// read provider files and interpolate locals
-func ParseProviderFile(verboseLogging bool, tmpProvider string, backedupProviderFile string, tmp string) ProviderConfig {
+func hasTags(provider AwsProviderConfigBody) bool {
+	return provider.DefaultTags != nil && provider.DefaultTags.Tags != cty.NilVal && !provider.DefaultTags.Tags.IsNull()
+}
 
-	if verboseLogging {
-		log.Println("parsing provider file")
+/*
+the default tags of every provider keyed by ProviderKey, so a provider of another type with the same alias or also
+unaliased doesn't replace the tags of an aws provider. Written as the complete evaluated tag map so every tag from
+locals, merge() or any other expression carries over. Tags depending on something only known at plan time, such as
+a data source, can't be evaluated so the original expression is written instead, the locals it references are still
+in the module. providerBlocks are the matching syntax tree blocks
+*/
+func ExtractDefaultTags(verbose bool, config []AwsProviderConfigBody, providerBlocks []*hclwrite.Block) map[string]string {
+
+	if verbose {
+		log.Println("extracting default tags")
 	}
 
-	locals := parseLocals(verboseLogging, tmp)
+	tagMapComplete := make(map[string]string)
 
-	hclConfigName := createHclCopy(verboseLogging, tmpProvider, backedupProviderFile, tmp)
+	for i, provider := range config {
+		alias := provider.Alias
+		if alias == "" {
+			alias = "unaliased"
+		}
+		key := ProviderKey(provider.Type, provider.Alias)
 
-	// fmt.Println(hclConfigName)
+		if !hasTags(provider) {
+			tagMapComplete[key] = "{}"
+			continue
+		}
 
-	/* hcl is a strucutred configuration language not a data structure serialization language like JSON,YAML or TOML
-		   hcl is always decoded using an application-defined schema not via tokenization.
-		   here local.management_account_id is defined by creating a local map[string]string and adding value
-	       management_account_id = 12345678910 etc
-		   this is required to read the providers.tf file where variables are declared in main.tf or some other file
-		   the values are discarded for the read only overrides.tf file be required to read
-	*/
+		tags := provider.DefaultTags.Tags
+		if tags.IsWhollyKnown() {
+			tagMapComplete[key] = string(hclwrite.TokensForValue(tags).Bytes())
+			continue
+		}
 
-	var defaultTags map[string]cty.Value
-	var repositoryName string
+		if verbose {
+			log.Printf("default tags of provider %s.%s can't be evaluated, keeping the original expression", provider.Type, alias)
+		}
 
-	for _, block := range locals {
+		tagMapComplete[key] = strings.TrimSpace(string(providerBlocks[i].Body().FirstMatchingBlock("default_tags", nil).Body().GetAttribute("tags").Expr().BuildTokens(nil).Bytes()))
+	}
 
-		for _, values := range block.Locals {
-			if values.DefaultTags != nil {
-				defaultTags = map[string]cty.Value{
-					"Repository": cty.StringVal(values.DefaultTags["Repository"]),
-				}
+	return tagMapComplete
+}
+
+// Remove a generated overrides file if present, in either syntax since apply may have been run with or without --json
+func RemoveOverrideFile(verboseLogging bool, overrideFile string) {
+
+	native := strings.TrimSuffix(overrideFile, jsonFileExtension)
+
+	for _, f := range []string{native, native + jsonFileExtension} {
+		_, err := os.Stat(f)
+
+		if err != nil {
+			if verboseLogging {
+				log.Printf("no %s file detected, skipping cleanup", f)
 			}
-			if values.RepositoryName != "" {
-				repositoryName = values.RepositoryName
+		} else {
+			if verboseLogging {
+				log.Println("cleaning up previous:", f)
 			}
+			err = os.Remove(f)
+			check(err)
 		}
 	}
+}
 
-	// if not local.default_tags set discard, can't set empty cty.MapVal()
-	if len(defaultTags) == 0 {
-		defaultTags = map[string]cty.Value{
-			"discarded": cty.StringVal("discarded"),
-		}
+// Remove the overrides file and restore every backed up file to working order
+func RestoreProvider(verboseLogging bool, overrideFile string) {
+
+	RemoveOverrideFile(verboseLogging, overrideFile)
+
+	var backups []string
+	for _, extension := range terraformFileExtensions {
+		matches, err := filepath.Glob("*" + extension + providerBackupFileExtension)
+		check(err)
+		backups = append(backups, matches...)
 	}
 
-	ctx := &hcl.EvalContext{
-		Variables: map[string]cty.Value{"local": cty.ObjectVal(map[string]cty.Value{
-			"default_tags":          cty.MapVal(defaultTags),
-			"repository_name":       cty.StringVal(repositoryName),
-			"management_account_id": cty.StringVal("12345678910"),
-			"account_number":        cty.StringVal("12345678910"),
-			"build_account_id":      cty.StringVal("12345678910"),
-			"transit_account_id":    cty.StringVal("12345678910"),
-			"hmpo_account_id":       cty.StringVal("12345678910"),
-			"logs_account_id":       cty.StringVal("12345678910"),
-		},
-		),
-		},
+	if len(backups) == 0 && verboseLogging {
+		log.Println("no backup files detected, skipping restore")
 	}
 
-	var config ProviderConfig
-	err := hclsimple.DecodeFile(hclConfigName, ctx, &config)
-	defer os.Remove(hclConfigName)
-	check(err)
-	return config
+	for _, backup := range backups {
+		restoreProviderFile(verboseLogging, backup)
+	}
 }
 
-func ParseOverrideConfig(mappingFile string) OverrideConfig {
+/*
+The original file is put back byte for byte unless it was edited while overrides were applied, in that case
+the edits are kept and the provider blocks, along with any terraform block whose backend was rewritten, are
+put back at the end of the file
+*/
+func restoreProviderFile(verboseLogging bool, backup string) {
+	original := strings.TrimSuffix(backup, providerBackupFileExtension)
 
-	hclConfig, err := os.OpenFile(mappingFile, os.O_RDONLY, 0644)
+	backupSrc, err := os.ReadFile(backup)
 	check(err)
 
-	defer hclConfig.Close()
+	current, err := os.ReadFile(original)
+	if err != nil || bytes.Equal(current, neutraliseProviders(backupSrc, backup, false)) || bytes.Equal(current, neutraliseProviders(backupSrc, backup, true)) {
+		if verboseLogging {
+			log.Printf("restoring providers file %s to %s", backup, original)
+		}
+		err = os.Rename(backup, original)
+		check(err)
+		return
+	}
+
+	log.Printf("%s was edited while overrides were applied, keeping edits and restoring its provider blocks", original)
+
+	if isJSONFile(backup) {
+		err = os.WriteFile(original, spliceJSONProviders(current, original, backupSrc, backup), 0644)
+		check(err)
+
+		err = os.Remove(backup)
+		check(err)
+		return
+	}
+
+	backupFile := parseSyntax(backupSrc, backup)
+	currentFile := parseSyntax(current, original)
 
-	var config OverrideConfig
+	/*
+		the provider blocks are copied back from the backup as written and appended. When the backup declares the
+		backend its terraform blocks are copied back in place of the ones written while overrides were applied, the
+		edited file is otherwise left byte for byte as it is
+	*/
+	var splices []splice
+	var restored []byte
+	if syntaxHasBackend(backupFile) {
+		var terraform []byte
+		for _, block := range syntaxBlocks(backupFile, "terraform") {
+			terraform = append(terraform, blockSource(backupSrc, block)...)
+		}
+		terraform = bytes.TrimPrefix(terraform, []byte("\n"))
+
+		for i, block := range syntaxBlocks(currentFile, "terraform") {
+			if i == 0 {
+				start, end := blockLines(current, block)
+				splices = append(splices, splice{start, end, string(terraform)})
+				continue
+			}
+			splices = append(splices, removeBlock(current, block))
+		}
+		if len(splices) == 0 {
+			restored = append(restored, '\n')
+			restored = append(restored, terraform...)
+		}
+	}
 
-	err = hclsimple.DecodeFile(hclConfig.Name(), nil, &config)
+	for _, block := range syntaxBlocks(backupFile, "provider") {
+		restored = append(restored, blockSource(backupSrc, block)...)
+	}
+
+	restoredFile := applySplices(current, splices)
+	if len(restored) > 0 {
+		restoredFile = append(bytes.TrimRight(restoredFile, "\r\n"), '\n')
+	}
+	restoredFile = append(restoredFile, restored...)
+
+	err = os.WriteFile(original, restoredFile, 0644)
 	check(err)
 
-	return config
+	err = os.Remove(backup)
+	check(err)
 }
 
-// take non-deterministic shape of any default tags and convert to appropriately from hcl to go for writing to file
-// determine which locals keys need to be resolved to local.values
-func ExtractDefaultTags(verbose bool, config []AwsProviderConfigBody) map[string]string {
-
-	if verbose {
-		log.Println("extracting default tags")
+// the file as it's left while overrides are applied, without provider blocks and optionally pointing at a local backend
+func neutraliseProviders(src []byte, filename string, localBackend bool) []byte {
+	if isJSONFile(filename) {
+		return neutraliseJSONProviders(src, filename, localBackend)
 	}
 
-	tagMapSorting := make(map[string][]string)
+	file := parseSyntax(src, filename)
 
-	for _, provider := range config {
-		var groupTags []string
-		if provider.DefaultTags != nil {
-			for key, value := range provider.DefaultTags.Tags {
-				groupTags = append(groupTags, fmt.Sprintf("   %v   =   \"%v\"\n", key, value))
-				if provider.Alias == "" {
-					tagMapSorting["unaliased"] = groupTags
-				} else {
-					tagMapSorting[provider.Alias] = groupTags
-				}
-			}
+	// only the removed and rewritten blocks change, every other byte is left as written
+	var splices []splice
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		switch {
+		case block.Type == "provider":
+			splices = append(splices, removeBlock(src, block))
+		case block.Type == "terraform" && localBackend:
+			splices = append(splices, localBackendSplices(src, block)...)
 		}
 	}
 
-	var tagString string
+	return applySplices(src, splices)
+}
 
-	tagMapComplete := make(map[string]string)
+// backend or cloud block declared in a terraform block
+func syntaxHasBackend(file *hcl.File) bool {
+	for _, block := range syntaxBlocks(file, "terraform") {
+		for _, nested := range block.Body.Blocks {
+			if nested.Type == "backend" || nested.Type == "cloud" {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	if len(tagMapSorting) > 0 {
-		for key, values := range tagMapSorting {
-			tagString = ""
-			for _, tag := range values {
-				tagString += fmt.Sprintf("%v", tag)
+func hasBackend(file *hclwrite.File) bool {
+	for _, block := range TerraformBlocks(file) {
+		for _, nested := range block.Body().Blocks() {
+			if nested.Type() == "backend" || nested.Type() == "cloud" {
+				return true
 			}
-			tagMapComplete[key] = tagString
 		}
-		return tagMapComplete
 	}
-	return tagMapComplete
+	return false
 }
 
-// Remove the .override file and restore the current working directory to working order
-func RestoreProvider(verboseLogging bool, overrideFile string) (string, string) {
-	providersFile, backedupProviderFile := setProvidersFile(verboseLogging)
+// module files declaring at least one provider block, read in place when providers are overridden natively
+func ProviderFiles(verboseLogging bool) []string {
+	files := providerFiles(verboseLogging)
 
-	_, err := os.Stat(overrideFile)
+	if len(files) == 0 {
+		Fail(errors.New("no provider blocks detected in any .tf, .tofu or json file"))
+	}
 
-	if err != nil {
-		if verboseLogging {
-			log.Printf("no %s file detected, skipping cleanup", overrideFile)
-		}
-	} else {
-		if verboseLogging {
-			log.Println("cleaning up previous:", overrideFile)
-		}
-		err = os.Remove(overrideFile)
+	return files
+}
+
+func providerFiles(verboseLogging bool) []string {
+	var providerFiles []string
+
+	diagnostics := newFileDiagnostics()
+
+	for _, f := range terraformFiles(verboseLogging, ".") {
+		src, err := os.ReadFile(f)
 		check(err)
+
+		providers, _, diags := inspectFile(src, f)
+		diagnostics.add(f, &hcl.File{Bytes: src}, diags)
+
+		if providers > 0 {
+			providerFiles = append(providerFiles, f)
+		}
 	}
 
-	_, err = os.Stat(backedupProviderFile)
+	diagnostics.report()
 
-	if err != nil {
-		if verboseLogging {
-			log.Printf("no %s backup file detected, skipping restore", backedupProviderFile)
+	return providerFiles
+}
+
+// a file apply backs up, it declares providers or with a local backend a backend
+type touchedFile struct {
+	name      string
+	src       []byte
+	providers int
+}
+
+// every file apply backs up, nothing is backed up until every file has parsed so errors are reported first
+func touchedFiles(verboseLogging bool, localBackend bool) []touchedFile {
+	var touched []touchedFile
+
+	diagnostics := newFileDiagnostics()
+
+	for _, f := range terraformFiles(verboseLogging, ".") {
+		src, err := os.ReadFile(f)
+		check(err)
+
+		providers, backend, diags := inspectFile(src, f)
+		diagnostics.add(f, &hcl.File{Bytes: src}, diags)
+		if providers == 0 && !(localBackend && backend) {
+			continue
 		}
-	} else {
+
 		if verboseLogging {
-			log.Printf("restoring providers file %s to %s", backedupProviderFile, providersFile)
+			log.Printf("found %d provider blocks in %s", providers, f)
 		}
-		err = os.Rename(backedupProviderFile, providersFile)
-		if err != nil {
-			check(err)
+
+		touched = append(touched, touchedFile{f, src, providers})
+	}
+
+	diagnostics.report()
+
+	return touched
+}
+
+/*
+the files apply backs up that are written in json syntax, split into those declaring providers and those only
+declaring the backend replaced by a local backend
+*/
+func JSONBackupFiles(verboseLogging bool, localBackend bool) ([]string, []string) {
+	var providerFiles, backendFiles []string
+	for _, f := range touchedFiles(verboseLogging, localBackend) {
+		switch {
+		case !isJSONFile(f.name):
+		case f.providers > 0:
+			providerFiles = append(providerFiles, f.name)
+		default:
+			backendFiles = append(backendFiles, f.name)
 		}
 	}
-	return providersFile, backedupProviderFile
+	return providerFiles, backendFiles
 }
 
-// Backup the providers.tf file and create and overrides.tf file that will work in its place
-func BackupProvider(verboseLogging bool, overrideFile string, providerFile string, backedupProviderFile string) {
+/*
+Find every provider block in the root module regardless of which file it's in, back up each file that declares
+one as <file>.overrides and remove only its provider blocks so the rest of the file stays part of the module.
+With localBackend files declaring a backend are also backed up and their backend is rewritten.
+Returns the backup files for parsing, then create the overrides.tf file that will work in their place
+*/
+func BackupProvider(verboseLogging bool, overrideFile string, localBackend bool) []string {
+
+	touched := touchedFiles(verboseLogging, localBackend)
 
-	if verboseLogging {
-		log.Printf("backing up provider file %s as %s", providerFile, backedupProviderFile)
+	providerCount := 0
+	for _, f := range touched {
+		providerCount += f.providers
+	}
+
+	if providerCount == 0 {
+		Fail(errors.New("no provider blocks detected in any .tf, .tofu or json file"))
 	}
 
-	err := os.Rename(providerFile, backedupProviderFile)
+	var backups []string
+
+	for _, f := range touched {
+		backup := f.name + providerBackupFileExtension
 
-	if err != nil {
 		if verboseLogging {
-			log.Printf("error backing up provider file %s as %s", providerFile, backedupProviderFile)
+			log.Printf("backing up provider file %s as %s", f.name, backup)
 		}
+
+		err := os.WriteFile(backup, f.src, 0644)
+		if err != nil {
+			if verboseLogging {
+				log.Printf("error backing up provider file %s as %s", f.name, backup)
+			}
+			check(err)
+		}
+
+		err = os.WriteFile(f.name, neutraliseProviders(f.src, f.name, localBackend), 0644)
 		check(err)
+
+		backups = append(backups, backup)
 	}
 
 	if verboseLogging {
@@ -447,4 +742,6 @@ func BackupProvider(verboseLogging bool, overrideFile string, providerFile strin
 	}
 
 	defer file.Close()
+
+	return backups
 }
@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const mainSrc string = `terraform {
+  required_version = ">= 1.5"
+
+  backend "s3" {
+    bucket = "state"
+  }
+}
+
+provider "aws" {
+  region = "eu-west-1"
+}
+
+locals {
+  env = "dev"
+}
+
+provider "aws" {
+  alias  = "logs"
+  region = "eu-west-2"
+}
+`
+
+const mainJSONSrc string = `{
+  "provider": {
+    "aws": {
+      "region": "eu-west-1"
+    }
+  },
+  "locals": {
+    "env": "dev"
+  }
+}
+`
+
+func TestNeutraliseProviders(t *testing.T) {
+	tests := []struct {
+		name         string
+		filename     string
+		src          string
+		localBackend bool
+		want         string
+	}{
+		{
+			// only the provider blocks go, every other byte is left as written
+			name:     "providers removed",
+			filename: "main.tf",
+			src:      mainSrc,
+			want:     "terraform {\n  required_version = \">= 1.5\"\n\n  backend \"s3\" {\n    bucket = \"state\"\n  }\n}\n\n\nlocals {\n  env = \"dev\"\n}\n\n",
+		},
+		{
+			name:         "local backend",
+			filename:     "main.tf",
+			src:          mainSrc,
+			localBackend: true,
+			want:         "terraform {\n  required_version = \">= 1.5\"\n\n  backend \"local\" {}\n}\n\n\nlocals {\n  env = \"dev\"\n}\n\n",
+		},
+		{
+			name:     "crlf kept",
+			filename: "main.tf",
+			src:      strings.ReplaceAll(mainSrc, "\n", "\r\n"),
+			want:     strings.ReplaceAll("terraform {\n  required_version = \">= 1.5\"\n\n  backend \"s3\" {\n    bucket = \"state\"\n  }\n}\n\n\nlocals {\n  env = \"dev\"\n}\n\n", "\n", "\r\n"),
+		},
+		{
+			name:     "json",
+			filename: "main.tf.json",
+			src:      mainJSONSrc,
+			want:     "{\n  \"locals\": {\n    \"env\": \"dev\"\n  }\n}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(neutraliseProviders([]byte(test.src), test.filename, test.localBackend)); got != test.want {
+				t.Errorf("neutraliseProviders = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRestoreProviderFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		filename     string
+		src          string
+		localBackend bool
+		edit         func(string) string // applied to the file while overrides are applied, nil when left alone
+		want         string              // empty when the original is put back byte for byte
+	}{
+		{
+			name:     "unedited",
+			filename: "main.tf",
+			src:      mainSrc,
+		},
+		{
+			name:         "unedited with a local backend",
+			filename:     "main.tf",
+			src:          mainSrc,
+			localBackend: true,
+		},
+		{
+			name:     "unedited crlf",
+			filename: "main.tf",
+			src:      strings.ReplaceAll(mainSrc, "\n", "\r\n"),
+		},
+		{
+			name:     "unedited json",
+			filename: "main.tf.json",
+			src:      mainJSONSrc,
+		},
+		{
+			name:     "edited",
+			filename: "main.tf",
+			src:      mainSrc,
+			edit: func(src string) string {
+				return strings.Replace(src, `env = "dev"`, `env = "prod"`, 1)
+			},
+			want: "terraform {\n  required_version = \">= 1.5\"\n\n  backend \"s3\" {\n    bucket = \"state\"\n  }\n}\n\n\nlocals {\n  env = \"prod\"\n}\n" +
+				"\nprovider \"aws\" {\n  region = \"eu-west-1\"\n}\n\nprovider \"aws\" {\n  alias  = \"logs\"\n  region = \"eu-west-2\"\n}\n",
+		},
+		{
+			// the backend written by --local-backend is replaced in place with the original terraform block
+			name:         "edited with a local backend",
+			filename:     "main.tf",
+			src:          mainSrc,
+			localBackend: true,
+			edit: func(src string) string {
+				return src + "output \"env\" {\n  value = local.env\n}\n"
+			},
+			want: "terraform {\n  required_version = \">= 1.5\"\n\n  backend \"s3\" {\n    bucket = \"state\"\n  }\n}\n\n\nlocals {\n  env = \"dev\"\n}\n\noutput \"env\" {\n  value = local.env\n}\n" +
+				"\nprovider \"aws\" {\n  region = \"eu-west-1\"\n}\n\nprovider \"aws\" {\n  alias  = \"logs\"\n  region = \"eu-west-2\"\n}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, map[string]string{test.filename + providerBackupFileExtension: test.src})
+
+			current := string(neutraliseProviders([]byte(test.src), test.filename, test.localBackend))
+			if test.edit != nil {
+				current = test.edit(current)
+			}
+			if err := os.WriteFile(test.filename, []byte(current), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			restoreProviderFile(false, test.filename+providerBackupFileExtension)
+
+			got, err := os.ReadFile(test.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.want
+			if want == "" {
+				want = test.src
+			}
+			if string(got) != want {
+				t.Errorf("restored %q, want %q", got, want)
+			}
+			if _, err := os.Stat(test.filename + providerBackupFileExtension); !os.IsNotExist(err) {
+				t.Errorf("backup left behind after restore")
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+/*
+fill in Region, evaluated when locals and variables are enough to know it, otherwise RegionSource keeps the expression
+in native syntax so a variable without a value or a data source is still resolved by terraform at plan time
+*/
+func setProviderRegion(provider *AwsProviderConfigBody, src []byte, json bool, ctx *hcl.EvalContext) {
+	if provider.RegionAttribute == nil {
+		return
+	}
+
+	expr := provider.RegionAttribute.Expr
+	value, diags := expr.Value(ctx)
+	if !diags.HasErrors() && value.IsWhollyKnown() && !value.IsNull() && value.Type() == cty.String {
+		provider.Region = value.AsString()
+		return
+	}
+
+	provider.RegionSource = expressionSource(expr, src, json)
+}
+
+// an expression as written in native syntax, the template of a json string is converted
+func expressionSource(expr hcl.Expression, src []byte, json bool) string {
+	exprRange := expr.Range()
+	if exprRange.End.Byte > len(src) {
+		return ""
+	}
+	source := string(src[exprRange.Start.Byte:exprRange.End.Byte])
+
+	if json {
+		return jsonTemplateSource(source)
+	}
+	return source
+}
+
+// the native syntax of a json string template, "${var.region}" is the expression var.region
+func jsonTemplateSource(literal string) string {
+	var template string
+	if err := json.Unmarshal([]byte(literal), &template); err != nil {
+		return ""
+	}
+
+	inner := strings.TrimSuffix(strings.TrimPrefix(template, "${"), "}")
+	if strings.HasPrefix(template, "${") && strings.HasSuffix(template, "}") && !strings.Contains(inner, "${") {
+		return inner
+	}
+
+	quoted, _ := json.Marshal(template)
+	return string(quoted)
+}
@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const remoteStateDataSource string = "terraform_remote_state"
+
+// one item of a config object, Expr is the value as written in native syntax
+type ConfigItem struct {
+	Key  string
+	Expr string
+}
+
+// a data "terraform_remote_state" block reading state from an s3 backend, Config is its config object as written
+type RemoteState struct {
+	File   string
+	Name   string
+	Config []ConfigItem
+}
+
+func (state RemoteState) Address() string {
+	return fmt.Sprintf("data.%s.%s", remoteStateDataSource, state.Name)
+}
+
+// the key of an object item, a bare name or a quoted string
+func objectKey(item hclsyntax.ObjectConsItem) (string, bool) {
+	if keyword := hcl.ExprAsKeyword(item.KeyExpr); keyword != "" {
+		return keyword, true
+	}
+
+	key, diags := item.KeyExpr.Value(nil)
+	if diags.HasErrors() || !key.IsKnown() || key.IsNull() || key.Type() != cty.String {
+		return "", false
+	}
+	return key.AsString(), true
+}
+
+// config items of a native syntax data source, false when config isn't written as an object
+func nativeRemoteStateConfig(block *hclsyntax.Block, src []byte) ([]ConfigItem, bool) {
+	attribute, ok := block.Body.Attributes["config"]
+	if !ok {
+		return nil, false
+	}
+
+	object, ok := attribute.Expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		return nil, false
+	}
+
+	var items []ConfigItem
+	for _, item := range object.Items {
+		key, ok := objectKey(item)
+		if !ok {
+			return nil, false
+		}
+		items = append(items, ConfigItem{key, expressionSource(item.ValueExpr, src, false)})
+	}
+	return items, true
+}
+
+// config items of a json syntax data source, json values read the same in native syntax apart from string templates
+func jsonRemoteStateConfig(body JSONObject) ([]ConfigItem, bool) {
+	config, ok := body["config"].(JSONObject)
+	if !ok {
+		return nil, false
+	}
+
+	var keys []string
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var items []ConfigItem
+	for _, key := range keys {
+		value := config[key]
+		encoded, err := json.Marshal(value)
+		check(err)
+
+		expr := string(encoded)
+		if _, ok := value.(string); ok {
+			expr = jsonTemplateSource(expr)
+		}
+		items = append(items, ConfigItem{key, expr})
+	}
+	return items, true
+}
+
+// literal backend of a data source, empty when it's an expression
+func remoteStateBackend(value hcl.Expression) string {
+	backend, diags := value.Value(nil)
+	if diags.HasErrors() || !backend.IsKnown() || backend.IsNull() || backend.Type() != cty.String {
+		return ""
+	}
+	return backend.AsString()
+}
+
+/*
+every data "terraform_remote_state" of the root module reading an s3 backend, in either syntax. Data sources whose
+config isn't written as an object, such as config = local.state, can't have their credentials picked out and are
+reported and skipped
+*/
+func RemoteStates(verbose bool) []RemoteState {
+	var states []RemoteState
+
+	for _, f := range terraformFiles(verbose, ".") {
+		src, err := os.ReadFile(f)
+		check(err)
+
+		if isJSONFile(f) {
+			for _, data := range jsonBlocks(decodeJSONObject(src, f)["data"]) {
+				for _, byName := range jsonBlocks(data[remoteStateDataSource]) {
+					for name, bodies := range byName {
+						for _, body := range jsonBlocks(bodies) {
+							if backend, _ := body["backend"].(string); backend != s3Backend {
+								log.Printf("data.%s.%s in %s doesn't read an s3 backend, skipping", remoteStateDataSource, name, f)
+								continue
+							}
+							config, ok := jsonRemoteStateConfig(body)
+							if !ok {
+								log.Printf("data.%s.%s in %s has no config object, skipping", remoteStateDataSource, name, f)
+								continue
+							}
+							states = append(states, RemoteState{f, name, config})
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		file, diags := hclsyntax.ParseConfig(src, f, hcl.InitialPos)
+		if diags.HasErrors() {
+			reportFileDiagnostics(f, src, diags)
+		}
+
+		for _, block := range syntaxBlocks(file, "data") {
+			if len(block.Labels) != 2 || block.Labels[0] != remoteStateDataSource {
+				continue
+			}
+			name := block.Labels[1]
+
+			backend, ok := block.Body.Attributes["backend"]
+			if !ok || remoteStateBackend(backend.Expr) != s3Backend {
+				log.Printf("data.%s.%s in %s doesn't read an s3 backend, skipping", remoteStateDataSource, name, f)
+				continue
+			}
+
+			config, ok := nativeRemoteStateConfig(block, src)
+			if !ok {
+				log.Printf("data.%s.%s in %s has no config object, skipping", remoteStateDataSource, name, f)
+				continue
+			}
+			states = append(states, RemoteState{f, name, config})
+		}
+	}
+
+	if verbose {
+		log.Printf("found %d %s data sources", len(states), remoteStateDataSource)
+	}
+
+	return states
+}
@@ -0,0 +1,171 @@
+package provider
+
+import (
+	"log"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// parse the backed up providers file keeping every token, nothing is evaluated or discarded
+func ParseProviderFileAST(verbose bool, backedupProviderFile string) *hclwrite.File {
+	if verbose {
+		log.Println("parsing provider file syntax tree", backedupProviderFile)
+	}
+
+	src, err := os.ReadFile(backedupProviderFile)
+	if err != nil {
+		if verbose {
+			log.Println("error reading provider file", backedupProviderFile)
+		}
+		check(err)
+	}
+
+	file, diags := hclwrite.ParseConfig(src, backedupProviderFile, hcl.InitialPos)
+	if diags.HasErrors() {
+		if verbose {
+			log.Println("error parsing provider file", backedupProviderFile)
+		}
+		reportFileDiagnostics(backedupProviderFile, src, diags)
+	}
+
+	return file
+}
+
+/*
+syntax trees of the backed up native syntax files, a json file is only backed up for its backend when overrides
+are written as native syntax, its backend is rewritten and restored by the json splice in json.go instead
+*/
+func ProviderFileASTs(verbose bool, backedupProviderFiles []string) []*hclwrite.File {
+	var files []*hclwrite.File
+	for _, backup := range backedupProviderFiles {
+		if isJSONFile(backup) {
+			if verbose {
+				log.Println("skipping syntax tree of json file", backup)
+			}
+			continue
+		}
+		files = append(files, ParseProviderFileAST(verbose, backup))
+	}
+	return files
+}
+
+// provider blocks in file order, given the same files the nth block is the nth provider decoded by ParseProviderFile
+func ProviderBlocks(files ...*hclwrite.File) []*hclwrite.Block {
+	var providers []*hclwrite.Block
+	for _, file := range files {
+		for _, block := range file.Body().Blocks() {
+			if block.Type() == "provider" {
+				providers = append(providers, block)
+			}
+		}
+	}
+	return providers
+}
+
+// terraform blocks holding required_providers, required_version, backend or cloud
+func TerraformBlocks(file *hclwrite.File) []*hclwrite.Block {
+	var terraform []*hclwrite.Block
+	for _, block := range file.Body().Blocks() {
+		if block.Type() == "terraform" {
+			terraform = append(terraform, block)
+		}
+	}
+	return terraform
+}
+
+// bytes start to end of a file replaced by text, an empty text removes them
+type splice struct {
+	start int
+	end   int
+	text  string
+}
+
+// the file with each splice applied, splices are in file order and don't overlap
+func applySplices(src []byte, splices []splice) []byte {
+	var out []byte
+	last := 0
+	for _, s := range splices {
+		out = append(out, src[last:s.start]...)
+		out = append(out, s.text...)
+		last = s.end
+	}
+	return append(out, src[last:]...)
+}
+
+func parseSyntax(src []byte, filename string) *hcl.File {
+	file, diags := hclsyntax.ParseConfig(src, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		reportFileDiagnostics(filename, src, diags)
+	}
+	return file
+}
+
+// the lines a block is written on, from its indent through its line break so removing it leaves no blank line behind
+func blockLines(src []byte, block *hclsyntax.Block) (int, int) {
+	start, end := block.Range().Start.Byte, block.Range().End.Byte
+	for start > 0 && (src[start-1] == ' ' || src[start-1] == '\t') {
+		start--
+	}
+	if end < len(src) && src[end] == '\r' {
+		end++
+	}
+	if end < len(src) && src[end] == '\n' {
+		end++
+	}
+	return start, end
+}
+
+func removeBlock(src []byte, block *hclsyntax.Block) splice {
+	start, end := blockLines(src, block)
+	return splice{start, end, ""}
+}
+
+// a block as written, after a blank line separating it from what it's appended to
+func blockSource(src []byte, block *hclsyntax.Block) []byte {
+	start, end := blockLines(src, block)
+	source := append([]byte("\n"), src[start:end]...)
+	if source[len(source)-1] != '\n' {
+		source = append(source, '\n')
+	}
+	return source
+}
+
+// the first backend or cloud block of a terraform block replaced by a local backend and any others removed
+func localBackendSplices(src []byte, terraform *hclsyntax.Block) []splice {
+	var splices []splice
+	for _, block := range terraform.Body.Blocks {
+		if block.Type != "backend" && block.Type != "cloud" {
+			continue
+		}
+		if len(splices) == 0 {
+			splices = append(splices, splice{block.Range().Start.Byte, block.Range().End.Byte, `backend "local" {}`})
+			continue
+		}
+		splices = append(splices, removeBlock(src, block))
+	}
+	return splices
+}
+
+// remove the named attributes and nested blocks from a provider block, anything not named is left as written
+func StripProviderAttributes(verbose bool, body *hclwrite.Body, strip []string) {
+	for _, name := range strip {
+		if body.GetAttribute(name) != nil {
+			if verbose {
+				log.Println("stripping provider attribute", name)
+			}
+			body.RemoveAttribute(name)
+		}
+
+		for _, block := range body.Blocks() {
+			if block.Type() == name {
+				if verbose {
+					log.Println("stripping provider block", name)
+				}
+				body.RemoveBlock(block)
+			}
+		}
+	}
+}
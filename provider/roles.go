@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const iamRoleResource string = ":role/"
+
+// the contents of a quoted string expression exactly as written, references such as ${local.account_id} are kept
+func quotedSource(expr hcl.Expression, src []byte) (string, bool) {
+	exprRange := expr.Range()
+	if exprRange.End.Byte > len(src) || exprRange.End.Byte-exprRange.Start.Byte < 2 {
+		return "", false
+	}
+
+	source := string(src[exprRange.Start.Byte:exprRange.End.Byte])
+	if !strings.HasPrefix(source, `"`) || !strings.HasSuffix(source, `"`) || strings.Contains(source, "\n") {
+		return "", false
+	}
+	return source[1 : len(source)-1], true
+}
+
+/*
+role_arn as the contents of a string template, evaluated when locals and variables are enough to know it and
+as written otherwise, so an account id coming from a data source is still resolved by terraform at plan time.
+Empty when it's neither known nor a quoted string
+*/
+func roleArnTemplate(expr hcl.Expression, src []byte, ctx *hcl.EvalContext) string {
+	value, diags := expr.Value(ctx)
+	if !diags.HasErrors() && value.IsWhollyKnown() && !value.IsNull() && value.Type() == cty.String {
+		escaped := strings.ReplaceAll(value.AsString(), "${", "$${")
+		return strings.ReplaceAll(escaped, "%{", "%%{")
+	}
+
+	source, _ := quotedSource(expr, src)
+	return source
+}
+
+/*
+point an assume_role at role in the same account, arn:<partition>:iam::<account>:role/<name> keeps everything up
+to :role/ so the partition and account, evaluated or as written, are unchanged. Anything that isn't an iam role arn
+is a configuration error, the provider would otherwise keep assuming the write role
+*/
+func ReadOnlyRoleArn(provider string, template string, role string) string {
+	if template == "" {
+		Fail(fmt.Errorf("provider %s assume_role role_arn can't be evaluated and isn't a quoted string, can't point it at %s", provider, role))
+	}
+
+	resource := strings.LastIndex(template, iamRoleResource)
+	if !strings.HasPrefix(template, "arn:") || !strings.Contains(template, ":iam::") || resource < 0 {
+		Fail(fmt.Errorf("provider %s assume_role role_arn %q is not an iam role arn, can't point it at %s", provider, template, role))
+	}
+
+	return template[:resource+len(iamRoleResource)] + role
+}
+
+/*
+every assume_role attribute but role_arn in the order written, an override replaces the whole block so they're
+written again next to the read only role_arn. Expressions are kept as written so references still resolve, a json
+value that isn't a string template is written evaluated and left out when it can't be
+*/
+func assumeRoleAttributes(body hcl.Body, src []byte, json bool, ctx *hcl.EvalContext) []AssumeRoleAttribute {
+	attrs, diags := body.JustAttributes()
+	if diags.HasErrors() {
+		return nil
+	}
+
+	sorted := make([]*hcl.Attribute, 0, len(attrs))
+	for name, attr := range attrs {
+		if name != "role_arn" {
+			sorted = append(sorted, attr)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Range.Start.Byte < sorted[j].Range.Start.Byte })
+
+	var attributes []AssumeRoleAttribute
+	for _, attr := range sorted {
+		source := expressionSource(attr.Expr, src, json)
+		if source == "" && json {
+			value, diags := attr.Expr.Value(ctx)
+			if diags.HasErrors() || !value.IsWhollyKnown() {
+				continue
+			}
+			source = string(hclwrite.TokensForValue(value).Bytes())
+		}
+		if source != "" {
+			attributes = append(attributes, AssumeRoleAttribute{Name: attr.Name, Expr: source})
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// terraform and terragrunt caches, along with any other hidden directory, never hold a root module of the repo
+func skippedDir(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+/*
+whether a directory is a root module overrides can be applied to, it declares a provider or backend, is run by
+terragrunt or still has overrides applied from an earlier run. overrideFiles are the files apply writes
+*/
+func isRootCandidate(verbose bool, dir string, overrideFiles []string) bool {
+	if _, err := os.Stat(filepath.Join(dir, TerragruntFile)); err == nil {
+		return true
+	}
+
+	for _, overrideFile := range overrideFiles {
+		if _, err := os.Stat(filepath.Join(dir, overrideFile)); err == nil {
+			return true
+		}
+	}
+
+	for _, extension := range terraformFileExtensions {
+		backups, err := filepath.Glob(filepath.Join(dir, "*"+extension+providerBackupFileExtension))
+		check(err)
+		if len(backups) > 0 {
+			return true
+		}
+	}
+
+	for _, f := range terraformFiles(verbose, dir) {
+		src, err := os.ReadFile(f)
+		check(err)
+
+		// a file that doesn't parse is still a root module, apply reports the error for that directory
+		providers, backend, diags := inspectFile(src, f)
+		if diags.HasErrors() || providers > 0 || backend {
+			return true
+		}
+	}
+
+	return false
+}
+
+// directories referenced as a local module source by a module, these are child modules and not applied on their own
+func localModuleSources(dir string) []string {
+	var sources []string
+
+	parser := hclparse.NewParser()
+	for _, f := range terraformFiles(false, dir) {
+		file, diags := parseTerraformFile(parser, f)
+		if diags.HasErrors() {
+			continue
+		}
+
+		for _, block := range moduleBlocks(file, "module", "name") {
+			if source := literalAttribute(block, "source"); isLocalSource(source) {
+				sources = append(sources, filepath.Clean(filepath.Join(dir, source)))
+			}
+		}
+	}
+
+	return sources
+}
+
+/*
+every root module below root. Directories declaring a provider block or a backend are candidates, then any candidate
+referenced as a local module source by another is dropped, as is a terragrunt.hcl with others below it, that's the
+configuration the others include rather than a module terragrunt runs
+*/
+func RootModules(verboseLogging bool, root string, overrideFiles []string) []string {
+	var candidates []string
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && skippedDir(entry.Name()) {
+			return filepath.SkipDir
+		}
+		if isRootCandidate(verboseLogging, path, overrideFiles) {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	check(err)
+
+	children := make(map[string]bool)
+	for _, dir := range candidates {
+		for _, source := range localModuleSources(dir) {
+			children[source] = true
+		}
+	}
+
+	terragrunt := make(map[string]bool)
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, TerragruntFile)); err == nil {
+			terragrunt[dir] = true
+		}
+	}
+
+	var roots []string
+	for _, dir := range candidates {
+		if children[dir] {
+			if verboseLogging {
+				log.Printf("%s is a child module, skipping", dir)
+			}
+			continue
+		}
+
+		if terragrunt[dir] && includesBelow(dir, terragrunt) {
+			if verboseLogging {
+				log.Printf("%s is terragrunt configuration included from below, skipping", dir)
+			}
+			continue
+		}
+
+		roots = append(roots, dir)
+	}
+	sort.Strings(roots)
+
+	return roots
+}
+
+// whether any other terragrunt directory is below dir
+func includesBelow(dir string, terragrunt map[string]bool) bool {
+	for other := range terragrunt {
+		if rel, err := filepath.Rel(dir, other); err == nil && other != dir && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
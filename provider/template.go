@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// the placeholder an account name goes in, the only one whose value may contain the text separating placeholders
+const accountPlaceholder string = "account"
+
+// the template profiles are read with when --alias or --set is used and mappings.hcl declares no profile_template
+const DefaultProfileTemplate string = "{org}-{account}-{env}-{role}"
+
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// the placeholders of a profile template in the order they're written
+func TemplatePlaceholders(template string) []string {
+	var placeholders []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		placeholders = append(placeholders, match[1])
+	}
+	return placeholders
+}
+
+// whether a profile template has a placeholder
+func HasPlaceholder(template string, name string) bool {
+	for _, placeholder := range TemplatePlaceholders(template) {
+		if placeholder == name {
+			return true
+		}
+	}
+	return false
+}
+
+// a problem with how a profile template is written, nil when profiles can be read with it unambiguously
+func checkProfileTemplate(template string) error {
+	placeholders := TemplatePlaceholders(template)
+	if len(placeholders) == 0 {
+		return fmt.Errorf("%q has no {placeholder}", template)
+	}
+
+	seen := make(map[string]bool)
+	for _, placeholder := range placeholders {
+		if seen[placeholder] {
+			return fmt.Errorf("{%s} is written more than once", placeholder)
+		}
+		seen[placeholder] = true
+	}
+
+	literals := placeholderPattern.Split(template, -1)
+	for i, literal := range literals {
+		if strings.ContainsAny(literal, "{}") {
+			return fmt.Errorf("%q is not a {placeholder}", literal)
+		}
+		// placeholders written next to each other can't be told apart
+		if literal == "" && i > 0 && i < len(literals)-1 {
+			return fmt.Errorf("{%s} and {%s} have nothing separating them", placeholders[i-1], placeholders[i])
+		}
+	}
+
+	return nil
+}
+
+// a character class matching none of the characters of separators, each written once and escaped where it has a meaning in a class
+func separatorClass(separators string) string {
+	class := "[^"
+	seen := make(map[rune]bool)
+	for _, r := range separators {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		if strings.ContainsRune(`\-]^[`, r) {
+			class += `\`
+		}
+		class += string(r)
+	}
+	return class + "]"
+}
+
+/*
+the regular expression reading a profile made up by a template. A placeholder matches text without any character
+separating the placeholders, {account} alone matches what's left so an account name can hold dashes
+*/
+func templateExpression(template string) *regexp.Regexp {
+	separators := strings.Join(placeholderPattern.Split(template, -1), "")
+
+	narrow := ".+"
+	if separators != "" {
+		narrow = separatorClass(separators) + "+"
+	}
+
+	expression := "^"
+	literals := placeholderPattern.Split(template, -1)
+	for i, placeholder := range TemplatePlaceholders(template) {
+		expression += regexp.QuoteMeta(literals[i])
+		if placeholder == accountPlaceholder {
+			expression += "(.+)"
+		} else {
+			expression += "(" + narrow + ")"
+		}
+	}
+	expression += regexp.QuoteMeta(literals[len(literals)-1]) + "$"
+
+	return regexp.MustCompile(expression)
+}
+
+// the value of each placeholder of a profile made up by a template, false when the profile doesn't match it
+func TemplateValues(template string, profile string) (map[string]string, bool) {
+	match := templateExpression(template).FindStringSubmatch(profile)
+	if match == nil {
+		return nil, false
+	}
+
+	values := make(map[string]string)
+	for i, placeholder := range TemplatePlaceholders(template) {
+		values[placeholder] = match[i+1]
+	}
+	return values, true
+}
+
+// the profile a template makes up from the value of each placeholder, along with the placeholders without a value
+func RenderTemplate(template string, values map[string]string) (string, []string) {
+	var missing []string
+	profile := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := values[name]
+		if !ok || value == "" {
+			missing = append(missing, placeholder)
+		}
+		return value
+	})
+	return profile, missing
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckProfileTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{DefaultProfileTemplate, true},
+		{"{account}-{role}", true},
+		{"sso/{account}/{role}", true},
+		{"{org}_{account}-{env}.{role}", true},
+		{"{org}+{account}-{env}_{role}", true},
+		{"no-placeholders", false},
+		{"{account}-{role}-{account}", false},
+		{"{account}{role}", false},
+		{"{account}-{role", false},
+		{"{account}-{1role}", false},
+	}
+
+	for _, test := range tests {
+		err := checkProfileTemplate(test.template)
+		if (err == nil) != test.valid {
+			t.Errorf("checkProfileTemplate(%q) = %v, want valid %v", test.template, err, test.valid)
+		}
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	tests := []struct {
+		template string
+		profile  string
+		values   map[string]string
+		ok       bool
+	}{
+		{
+			template: DefaultProfileTemplate,
+			profile:  "org-main-dev-ReadOnly",
+			values:   map[string]string{"org": "org", "account": "main", "env": "dev", "role": "ReadOnly"},
+			ok:       true,
+		},
+		{
+			// only {account} can hold the separator
+			template: DefaultProfileTemplate,
+			profile:  "org-logs-archive-prod-ReadOnly",
+			values:   map[string]string{"org": "org", "account": "logs-archive", "env": "prod", "role": "ReadOnly"},
+			ok:       true,
+		},
+		{
+			template: "sso/{account}/{role}",
+			profile:  "sso/main-dev/ReadOnly",
+			values:   map[string]string{"account": "main-dev", "role": "ReadOnly"},
+			ok:       true,
+		},
+		{
+			// separators with a meaning in a character class
+			template: "{org}_{account}-{env}.{role}",
+			profile:  "acme_logs-archive-prod.ReadOnly",
+			values:   map[string]string{"org": "acme", "account": "logs-archive", "env": "prod", "role": "ReadOnly"},
+			ok:       true,
+		},
+		{
+			template: "{org}+{account}-{env}_{role}",
+			profile:  "acme+logs-DEV_ReadOnly",
+			values:   map[string]string{"org": "acme", "account": "logs", "env": "DEV", "role": "ReadOnly"},
+			ok:       true,
+		},
+		{
+			template: `{org}]{account}^{env}\\{role}`,
+			profile:  `acme]logs^dev\\ReadOnly`,
+			values:   map[string]string{"org": "acme", "account": "logs", "env": "dev", "role": "ReadOnly"},
+			ok:       true,
+		},
+		{
+			// env can't hold a separator
+			template: "{org}+{account}-{env}_{role}",
+			profile:  "acme+logs-D+EV_ReadOnly",
+			ok:       false,
+		},
+		{
+			template: DefaultProfileTemplate,
+			profile:  "org-main-ReadOnly",
+			ok:       false,
+		},
+		{
+			template: "sso/{account}/{role}",
+			profile:  "org-main-dev-ReadOnly",
+			ok:       false,
+		},
+	}
+
+	for _, test := range tests {
+		values, ok := TemplateValues(test.template, test.profile)
+		if ok != test.ok || !reflect.DeepEqual(values, test.values) {
+			t.Errorf("TemplateValues(%q, %q) = %v, %v, want %v, %v", test.template, test.profile, values, ok, test.values, test.ok)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		values   map[string]string
+		profile  string
+		missing  []string
+	}{
+		{
+			template: DefaultProfileTemplate,
+			values:   map[string]string{"org": "org", "account": "main", "env": "staging", "role": "ReadOnly"},
+			profile:  "org-main-staging-ReadOnly",
+		},
+		{
+			template: DefaultProfileTemplate,
+			values:   map[string]string{"org": "org", "account": "main", "role": "ReadOnly"},
+			profile:  "org-main--ReadOnly",
+			missing:  []string{"{env}"},
+		},
+		{
+			// an empty value is as good as none
+			template: "{account}-{role}",
+			values:   map[string]string{"account": "", "role": ""},
+			profile:  "-",
+			missing:  []string{"{account}", "{role}"},
+		},
+		{
+			// values without a placeholder are left out
+			template: "{account}-{role}",
+			values:   map[string]string{"account": "main", "role": "ReadOnly", "env": "dev"},
+			profile:  "main-ReadOnly",
+		},
+	}
+
+	for _, test := range tests {
+		profile, missing := RenderTemplate(test.template, test.values)
+		if profile != test.profile || !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("RenderTemplate(%q, %v) = %q, %v, want %q, %v", test.template, test.values, profile, missing, test.profile, test.missing)
+		}
+	}
+}
+
+func TestHasPlaceholder(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+		has      bool
+	}{
+		{DefaultProfileTemplate, "env", true},
+		{DefaultProfileTemplate, "account", true},
+		{"{account}-{role}", "env", false},
+		{"{account}-{role}", "region", false},
+	}
+
+	for _, test := range tests {
+		if has := HasPlaceholder(test.template, test.name); has != test.has {
+			t.Errorf("HasPlaceholder(%q, %q) = %v, want %v", test.template, test.name, has, test.has)
+		}
+	}
+}
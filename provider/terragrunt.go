@@ -0,0 +1,354 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+const TerragruntFile string = "terragrunt.hcl"
+
+// terragrunt runs in a directory with a terragrunt.hcl, terraform only runs in the copy terragrunt makes in its cache
+func IsTerragruntDir() bool {
+	_, err := os.Stat(TerragruntFile)
+	return err == nil
+}
+
+// a terragrunt configuration file, parsed with the context its locals and generate blocks are evaluated in
+type terragruntConfig struct {
+	filename string
+	file     *hcl.File
+	body     *hclsyntax.Body
+	ctx      *hcl.EvalContext
+}
+
+// a generate block, the file terragrunt writes into its cache before running terraform
+type terragruntGenerate struct {
+	name     string
+	path     string
+	contents hcl.Expression
+	config   *terragruntConfig
+}
+
+/*
+the terragrunt built-in functions needed to find included configuration and read locals, evaluated the way terragrunt
+does from the directory of terragrunt.hcl. Anything else leaves the local or include depending on it unknown
+*/
+func terragruntFunctions(dir string) map[string]function.Function {
+	functions := terraformFunctions()
+
+	functions["get_terragrunt_dir"] = function.New(&function.Spec{
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return cty.StringVal(dir), nil
+		},
+	})
+
+	functions["get_env"] = function.New(&function.Spec{
+		Params:   []function.Parameter{{Name: "name", Type: cty.String}},
+		VarParam: &function.Parameter{Name: "default", Type: cty.String},
+		Type:     function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			if value, ok := os.LookupEnv(args[0].AsString()); ok {
+				return cty.StringVal(value), nil
+			}
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return cty.NilVal, fmt.Errorf("environment variable %s is not set", args[0].AsString())
+		},
+	})
+
+	// the first parent folder of dir containing name, terragrunt.hcl when no name is given, or fallback when there's none
+	functions["find_in_parent_folders"] = function.New(&function.Spec{
+		VarParam: &function.Parameter{Name: "args", Type: cty.String},
+		Type:     function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			name := TerragruntFile
+			if len(args) > 0 {
+				name = args[0].AsString()
+			}
+
+			for parent := filepath.Dir(dir); ; parent = filepath.Dir(parent) {
+				if _, err := os.Stat(filepath.Join(parent, name)); err == nil {
+					return cty.StringVal(filepath.Join(parent, name)), nil
+				}
+				if parent == filepath.Dir(parent) {
+					break
+				}
+			}
+
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return cty.NilVal, fmt.Errorf("no %s found in any parent folder of %s", name, dir)
+		},
+	})
+
+	return functions
+}
+
+// parse a terragrunt configuration and evaluate its locals, terragrunt locals aren't shared with included configuration
+func parseTerragruntConfig(verbose bool, filename string, functions map[string]function.Function, diagnostics *fileDiagnostics) (*terragruntConfig, bool) {
+	if verbose {
+		log.Println("parsing terragrunt configuration", filename)
+	}
+
+	file, diags := hclparse.NewParser().ParseHCLFile(filename)
+	diagnostics.add(filename, file, diags)
+	if diags.HasErrors() {
+		return nil, false
+	}
+
+	var locals LocalsBlock
+	diags = gohcl.DecodeBody(file.Body, nil, &locals)
+	diagnostics.add(filename, file, diags)
+	if diags.HasErrors() {
+		return nil, false
+	}
+
+	return &terragruntConfig{
+		filename: filename,
+		file:     file,
+		body:     file.Body.(*hclsyntax.Body),
+		ctx: &hcl.EvalContext{
+			Variables: map[string]cty.Value{
+				"local": cty.ObjectVal(evaluateLocals(verbose, []LocalsBlock{locals}, cty.EmptyObjectVal, functions)),
+			},
+			Functions: functions,
+		},
+	}, true
+}
+
+// the value of an attribute of a terragrunt block as a string, false when it's unset or only known once terragrunt runs
+func terragruntString(block *hclsyntax.Block, name string, ctx *hcl.EvalContext) (string, bool) {
+	attribute, ok := block.Body.Attributes[name]
+	if !ok {
+		return "", false
+	}
+
+	value, diags := attribute.Expr.Value(providerEvalContext(block.Body, ctx))
+	if diags.HasErrors() || !value.IsWhollyKnown() || value.IsNull() {
+		return "", false
+	}
+
+	value, err := convert.Convert(value, cty.String)
+	if err != nil {
+		return "", false
+	}
+	return value.AsString(), true
+}
+
+// the configuration files included by terragrunt.hcl, an include whose path can't be evaluated is skipped
+func terragruntIncludes(verbose bool, config *terragruntConfig) []string {
+	var includes []string
+
+	for _, block := range config.body.Blocks {
+		if block.Type != "include" {
+			continue
+		}
+
+		path, ok := terragruntString(block, "path", config.ctx)
+		if !ok {
+			log.Printf("include at %s has a path that can't be evaluated, its generate blocks are not read", block.DefRange())
+			continue
+		}
+
+		if verbose {
+			log.Println("terragrunt configuration includes", path)
+		}
+		includes = append(includes, path)
+	}
+
+	return includes
+}
+
+// the generate blocks of a configuration writing a .tf, .tofu or json file, disabled blocks are skipped
+func terragruntGenerates(verbose bool, config *terragruntConfig) []terragruntGenerate {
+	var generates []terragruntGenerate
+
+	for _, block := range config.body.Blocks {
+		if block.Type != "generate" || len(block.Labels) != 1 {
+			continue
+		}
+
+		if disable, ok := block.Body.Attributes["disable"]; ok {
+			value, diags := disable.Expr.Value(config.ctx)
+			if !diags.HasErrors() && value.IsKnown() && value.Type() == cty.Bool && value.True() {
+				if verbose {
+					log.Printf("generate %q is disabled", block.Labels[0])
+				}
+				continue
+			}
+		}
+
+		path, ok := terragruntString(block, "path", config.ctx)
+		if !ok {
+			log.Printf("generate %q at %s has a path that can't be evaluated, skipping", block.Labels[0], block.DefRange())
+			continue
+		}
+
+		if !isTerraformFile(path) {
+			if verbose {
+				log.Printf("generate %q writes %s, not a terraform file", block.Labels[0], path)
+			}
+			continue
+		}
+
+		contents, ok := block.Body.Attributes["contents"]
+		if !ok {
+			continue
+		}
+
+		generates = append(generates, terragruntGenerate{block.Labels[0], path, contents.Expr, config})
+	}
+
+	return generates
+}
+
+/*
+the body of a generated file. A heredoc is parsed as written in terragrunt.hcl so every expression keeps its position
+for diagnostics and references to terragrunt locals are evaluated with them, which is what terragrunt renders into
+the file. Contents that aren't a heredoc, or use template directives, are read from the rendered string instead
+*/
+func (generate terragruntGenerate) body() (string, *hcl.File, hcl.Body, hcl.Diagnostics) {
+	src := generate.config.file.Bytes
+	exprRange := generate.contents.Range()
+	json := isJSONFile(generate.path)
+
+	source := string(src[exprRange.Start.Byte:exprRange.End.Byte])
+	start := strings.Index(source, "\n")
+	end := strings.LastIndex(source, "\n")
+
+	if strings.HasPrefix(source, "<<") && start < end {
+		pos := hcl.Pos{Line: exprRange.Start.Line + 1, Column: 1, Byte: exprRange.Start.Byte + start + 1}
+		heredoc := src[pos.Byte : exprRange.Start.Byte+end+1]
+
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		if json {
+			file, diags = hcljson.ParseWithStartPos(heredoc, generate.config.filename, pos)
+		} else {
+			file, diags = hclsyntax.ParseConfig(heredoc, generate.config.filename, pos)
+		}
+		if !diags.HasErrors() {
+			return generate.config.filename, generate.config.file, file.Body, nil
+		}
+	}
+
+	// references terragrunt resolves when it runs, such as dependency outputs, are unknown
+	ctx := generate.config.ctx.NewChild()
+	ctx.Variables = make(map[string]cty.Value)
+	for _, traversal := range generate.contents.Variables() {
+		if _, ok := generate.config.ctx.Variables[traversal.RootName()]; !ok {
+			ctx.Variables[traversal.RootName()] = cty.DynamicVal
+		}
+	}
+
+	value, diags := generate.contents.Value(ctx)
+	if diags.HasErrors() || !value.IsWhollyKnown() || value.IsNull() || value.Type() != cty.String {
+		return generate.config.filename, generate.config.file, nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unreadable generate contents",
+			Detail:   fmt.Sprintf("The contents of generate %q can't be rendered before terragrunt runs.", generate.name),
+			Subject:  &exprRange,
+		}}
+	}
+
+	// diagnostics refer to the rendered file, named after the generate block
+	filename := fmt.Sprintf("%s (generate %q)", generate.config.filename, generate.name)
+	var file *hcl.File
+	if json {
+		file, diags = hcljson.Parse([]byte(value.AsString()), filename)
+	} else {
+		file, diags = hclsyntax.ParseConfig([]byte(value.AsString()), filename, hcl.InitialPos)
+	}
+	if diags.HasErrors() {
+		return filename, file, nil, diags
+	}
+	return filename, file, file.Body, nil
+}
+
+/*
+the provider blocks terragrunt generates into its cache, read from the generate blocks of terragrunt.hcl and of the
+configuration it includes. A generate block in terragrunt.hcl replaces the included block of the same name, as
+terragrunt merges them. Provider blocks in files next to terragrunt.hcl are read too, terragrunt copies them into
+the cache alongside. overrideFile is the file written next to terragrunt.hcl, which no generate block may write
+*/
+func TerragruntProviders(verboseLogging bool, overrideFile string, ctx *hcl.EvalContext) ProviderConfig {
+	dir, err := filepath.Abs(".")
+	check(err)
+
+	functions := terragruntFunctions(dir)
+	diagnostics := newFileDiagnostics()
+
+	config, ok := parseTerragruntConfig(verboseLogging, TerragruntFile, functions, diagnostics)
+	if !ok {
+		diagnostics.report()
+	}
+
+	var generates []terragruntGenerate
+	for _, include := range terragruntIncludes(verboseLogging, config) {
+		if included, ok := parseTerragruntConfig(verboseLogging, include, functions, diagnostics); ok {
+			generates = append(generates, terragruntGenerates(verboseLogging, included)...)
+		}
+	}
+
+	for _, generate := range terragruntGenerates(verboseLogging, config) {
+		replaced := false
+		for i := range generates {
+			if generates[i].name == generate.name {
+				if verboseLogging {
+					log.Printf("generate %q of %s replaces the included block", generate.name, TerragruntFile)
+				}
+				generates[i], replaced = generate, true
+			}
+		}
+		if !replaced {
+			generates = append(generates, generate)
+		}
+	}
+
+	var providers ProviderConfig
+	for _, generate := range generates {
+		if filepath.Base(generate.path) == filepath.Base(overrideFile) {
+			Fail(fmt.Errorf("generate %q of %s writes %s, the file overrides are written to", generate.name, generate.config.filename, generate.path))
+		}
+
+		if verboseLogging {
+			log.Printf("parsing generate %q of %s", generate.name, generate.config.filename)
+		}
+
+		filename, source, body, diags := generate.body()
+		diagnostics.add(filename, source, diags)
+		if diags.HasErrors() {
+			continue
+		}
+
+		generated := decodeProviderConfig(filename, source, body, isJSONFile(generate.path), generate.config.ctx, diagnostics)
+		providers.Providers = append(providers.Providers, generated.Providers...)
+	}
+
+	diagnostics.report()
+
+	if files := providerFiles(verboseLogging); len(files) > 0 {
+		providers.Providers = append(providers.Providers, ParseProviderFile(verboseLogging, files, ctx).Providers...)
+	}
+
+	if len(providers.Providers) == 0 {
+		Fail(fmt.Errorf("no provider blocks detected in any generate block of %s or any .tf, .tofu or json file", TerragruntFile))
+	}
+
+	return providers
+}
@@ -0,0 +1,261 @@
+package provider
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+const tfVarsFile string = "terraform.tfvars"
+const autoTfVarsFileExtension string = ".auto.tfvars"
+const jsonFileExtension string = ".json"
+const tfVarEnvPrefix string = "TF_VAR_"
+
+// type and default are kept as attributes, a missing default is not the same as default = null
+type VariableBlock struct {
+	Name    string         `hcl:"name,label"`
+	Type    *hcl.Attribute `hcl:"type,optional"`
+	Default *hcl.Attribute `hcl:"default,optional"`
+	Options hcl.Body       `hcl:",remain"` // discard
+}
+
+type declaredVariable struct {
+	constraint cty.Type
+	value      cty.Value
+}
+
+// collect every variable block and its default, variables without a default are unknown until a value is assigned
+func collectVariables(verbose bool, blocks []LocalsBlock, diagnostics *fileDiagnostics) map[string]*declaredVariable {
+	declared := make(map[string]*declaredVariable)
+
+	for _, block := range blocks {
+		for _, variable := range block.Variables {
+			if _, ok := declared[variable.Name]; ok {
+				if verbose {
+					log.Printf("duplicate variable %s, keeping first definition", variable.Name)
+				}
+				continue
+			}
+
+			constraint := cty.DynamicPseudoType
+			if variable.Type != nil {
+				ty, _, diags := typeexpr.TypeConstraintWithDefaults(variable.Type.Expr)
+				diagnostics.add(block.Filename, block.File, diags)
+				if diags.HasErrors() {
+					if verbose {
+						log.Printf("error reading type of variable %s", variable.Name)
+					}
+					continue
+				}
+				constraint = ty
+			}
+
+			value := cty.DynamicVal
+			if variable.Default != nil {
+				defaultValue, diags := variable.Default.Expr.Value(nil)
+				diagnostics.add(block.Filename, block.File, diags)
+				if diags.HasErrors() {
+					if verbose {
+						log.Printf("error reading default of variable %s", variable.Name)
+					}
+					continue
+				}
+				value = defaultValue
+			}
+
+			declared[variable.Name] = &declaredVariable{constraint: constraint, value: value}
+		}
+	}
+
+	return declared
+}
+
+// TF_VAR_name values are taken literally for strings, anything else is parsed as an hcl expression like terraform does
+func assignEnvironmentVariables(verbose bool, declared map[string]*declaredVariable, diagnostics *fileDiagnostics) {
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, tfVarEnvPrefix) {
+			continue
+		}
+
+		name, raw, _ := strings.Cut(strings.TrimPrefix(env, tfVarEnvPrefix), "=")
+		variable, ok := declared[name]
+		if !ok {
+			continue
+		}
+
+		if verbose {
+			log.Printf("setting var.%s from environment", name)
+		}
+
+		if variable.constraint == cty.DynamicPseudoType || variable.constraint == cty.String {
+			variable.value = cty.StringVal(raw)
+			continue
+		}
+
+		// diagnostics refer to the variable by its environment name, the value is the source of its snippet
+		source := &hcl.File{Bytes: []byte(raw)}
+
+		expr, diags := hclsyntax.ParseExpression([]byte(raw), tfVarEnvPrefix+name, hcl.InitialPos)
+		diagnostics.add(tfVarEnvPrefix+name, source, diags)
+		if diags.HasErrors() {
+			if verbose {
+				log.Printf("error parsing environment variable %s%s", tfVarEnvPrefix, name)
+			}
+			continue
+		}
+
+		value, diags := expr.Value(nil)
+		diagnostics.add(tfVarEnvPrefix+name, source, diags)
+		if diags.HasErrors() {
+			if verbose {
+				log.Printf("error evaluating environment variable %s%s", tfVarEnvPrefix, name)
+			}
+			continue
+		}
+		variable.value = value
+	}
+}
+
+// assign the values of a .tfvars or .tfvars.json file, values for undeclared variables are ignored
+func assignVarFile(verbose bool, parser *hclparse.Parser, varFile string, declared map[string]*declaredVariable, diagnostics *fileDiagnostics) {
+	if verbose {
+		log.Println("reading variables file", varFile)
+	}
+
+	var file *hcl.File
+	var diags hcl.Diagnostics
+
+	if strings.HasSuffix(varFile, jsonFileExtension) {
+		file, diags = parser.ParseJSONFile(varFile)
+	} else {
+		file, diags = parser.ParseHCLFile(varFile)
+	}
+
+	diagnostics.add(varFile, file, diags)
+	if diags.HasErrors() {
+		if verbose {
+			log.Println("error parsing variables file", varFile)
+		}
+		return
+	}
+
+	attributes, diags := file.Body.JustAttributes()
+	diagnostics.add(varFile, file, diags)
+	if diags.HasErrors() {
+		if verbose {
+			log.Println("error reading variables file", varFile)
+		}
+		return
+	}
+
+	for name, attribute := range attributes {
+		variable, ok := declared[name]
+		if !ok {
+			if verbose {
+				log.Printf("value for undeclared variable %s in %s, ignoring", name, varFile)
+			}
+			continue
+		}
+
+		value, diags := attribute.Expr.Value(nil)
+		diagnostics.add(varFile, file, diags)
+		if diags.HasErrors() {
+			if verbose {
+				log.Printf("error evaluating var.%s in %s", name, varFile)
+			}
+			continue
+		}
+		variable.value = value
+	}
+}
+
+// terraform.tfvars, terraform.tfvars.json then *.auto.tfvars and *.auto.tfvars.json in lexical order
+func autoVarFiles(verbose bool) []string {
+	var varFiles []string
+
+	for _, name := range []string{tfVarsFile, tfVarsFile + jsonFileExtension} {
+		if _, err := os.Stat(name); err == nil {
+			varFiles = append(varFiles, name)
+		}
+	}
+
+	autoFiles, err := filepath.Glob("*" + autoTfVarsFileExtension)
+	if err != nil {
+		if verbose {
+			log.Println("error searching for", autoTfVarsFileExtension, "files")
+		}
+		check(err)
+	}
+
+	autoJsonFiles, err := filepath.Glob("*" + autoTfVarsFileExtension + jsonFileExtension)
+	if err != nil {
+		if verbose {
+			log.Println("error searching for", autoTfVarsFileExtension+jsonFileExtension, "files")
+		}
+		check(err)
+	}
+
+	autoFiles = append(autoFiles, autoJsonFiles...)
+	sort.Strings(autoFiles)
+
+	return append(varFiles, autoFiles...)
+}
+
+/*
+input variables follow terraforms precedence, later sources override earlier ones
+  - variable defaults
+  - TF_VAR_ environment variables
+  - terraform.tfvars and terraform.tfvars.json
+  - *.auto.tfvars and *.auto.tfvars.json in lexical order
+  - any -var-file passed to apply, in the order given
+*/
+func evaluateVariables(verbose bool, blocks []LocalsBlock, varFiles []string) map[string]cty.Value {
+
+	if verbose {
+		log.Println("evaluating variables")
+	}
+
+	// declarations, the environment and variables files are all read before reporting so every error is shown at once
+	diagnostics := newFileDiagnostics()
+
+	declared := collectVariables(verbose, blocks, diagnostics)
+
+	assignEnvironmentVariables(verbose, declared, diagnostics)
+
+	parser := hclparse.NewParser()
+	for _, varFile := range append(autoVarFiles(verbose), varFiles...) {
+		assignVarFile(verbose, parser, varFile, declared, diagnostics)
+	}
+	diagnostics.report()
+
+	variables := make(map[string]cty.Value)
+	for name, variable := range declared {
+		if !variable.value.IsKnown() {
+			if verbose {
+				log.Printf("var.%s has no default or assigned value, setting unknown", name)
+			}
+			variables[name] = cty.UnknownVal(variable.constraint)
+			continue
+		}
+
+		value, err := convert.Convert(variable.value, variable.constraint)
+		if err != nil {
+			if verbose {
+				log.Printf("value of var.%s does not match its type", name)
+			}
+			check(err)
+		}
+		variables[name] = value
+	}
+
+	return variables
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// run in an empty directory holding files, the variables files terraform reads on its own are found from there
+func inTempDir(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestEvaluateVariables(t *testing.T) {
+	declarations := `
+variable "env" {
+  default = "default"
+}
+
+variable "account_id" {
+  type = string
+}
+
+variable "retention" {
+  type    = number
+  default = 7
+}
+
+variable "account_ids" {
+  type    = list(string)
+  default = []
+}`
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		env      map[string]string
+		varFiles []string
+		values   map[string]cty.Value
+	}{
+		{
+			name: "defaults",
+			values: map[string]cty.Value{
+				"env":         cty.StringVal("default"),
+				"account_id":  cty.UnknownVal(cty.String),
+				"retention":   cty.NumberIntVal(7),
+				"account_ids": cty.ListValEmpty(cty.String),
+			},
+		},
+		{
+			name: "environment over default",
+			env:  map[string]string{"TF_VAR_env": "environment", "TF_VAR_retention": "30"},
+			values: map[string]cty.Value{
+				"env":       cty.StringVal("environment"),
+				"retention": cty.NumberIntVal(30),
+			},
+		},
+		{
+			// strings aren't parsed, anything else is an hcl expression
+			name: "environment string taken literally",
+			env:  map[string]string{"TF_VAR_account_id": `["222233334444"]`, "TF_VAR_account_ids": `["222233334444"]`},
+			values: map[string]cty.Value{
+				"account_id":  cty.StringVal(`["222233334444"]`),
+				"account_ids": cty.ListVal([]cty.Value{cty.StringVal("222233334444")}),
+			},
+		},
+		{
+			name:   "terraform.tfvars over environment",
+			files:  map[string]string{"terraform.tfvars": `env = "tfvars"`},
+			env:    map[string]string{"TF_VAR_env": "environment"},
+			values: map[string]cty.Value{"env": cty.StringVal("tfvars")},
+		},
+		{
+			name: "auto.tfvars over terraform.tfvars in lexical order",
+			files: map[string]string{
+				"terraform.tfvars":      `env = "tfvars"`,
+				"a.auto.tfvars":         `env = "a"`,
+				"b.auto.tfvars.json":    `{"env": "b"}`,
+				"terraform.tfvars.json": `{"env": "tfvars json"}`,
+			},
+			values: map[string]cty.Value{"env": cty.StringVal("b")},
+		},
+		{
+			name: "var-file over auto.tfvars in the order given",
+			files: map[string]string{
+				"a.auto.tfvars": `env = "a"`,
+				"first.tfvars":  `env = "first"`,
+				"second.tfvars": "env = \"second\"\naccount_id = \"111122223333\"",
+			},
+			varFiles: []string{"second.tfvars", "first.tfvars"},
+			values: map[string]cty.Value{
+				"env":        cty.StringVal("first"),
+				"account_id": cty.StringVal("111122223333"),
+			},
+		},
+		{
+			name:  "converted to the declared type",
+			files: map[string]string{"terraform.tfvars": "retention = \"14\"\naccount_ids = [222233334444, \"111122223333\"]"},
+			values: map[string]cty.Value{
+				"retention":   cty.NumberIntVal(14),
+				"account_ids": cty.ListVal([]cty.Value{cty.StringVal("222233334444"), cty.StringVal("111122223333")}),
+			},
+		},
+		{
+			name:   "undeclared variables ignored",
+			files:  map[string]string{"terraform.tfvars": `region = "eu-west-1"`},
+			env:    map[string]string{"TF_VAR_profile": "default"},
+			values: map[string]cty.Value{"env": cty.StringVal("default")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inTempDir(t, test.files)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			variables := evaluateVariables(false, decodeLocalsBlocks(t, declarations), test.varFiles)
+
+			if len(variables) != 4 {
+				t.Errorf("got %d variables, want the 4 declared", len(variables))
+			}
+			for name, want := range test.values {
+				if got := variables[name]; !got.RawEquals(want) {
+					t.Errorf("var.%s = %#v, want %#v", name, got, want)
+				}
+			}
+		})
+	}
+}